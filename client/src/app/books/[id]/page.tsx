@@ -18,7 +18,7 @@ import { Badge } from "@/components/ui/badge"
 import { api } from "@/lib/api"
 import { getCurrentUser } from "@/lib/auth"
 import type { Book } from "@/types"
-import { stringToColor, formatPhoneNumber, isBookOwner } from "@/lib/utils"
+import { stringToColor, formatPhoneNumber, isBookOwner, bookStatusLabel, isRequestable, isWaitlisted, formatMoney } from "@/lib/utils"
 import { toast } from "sonner"
 
 export default function BookDetailPage() {
@@ -73,13 +73,13 @@ export default function BookDetailPage() {
     setRequestingBook(true);
     try {
         await api.post(`/books/${id}/request`, {});
-        toast.success("Book request sent successfully");
-        const response = await api.get<{ book: Book }>(`/books/${id}`);
-        setBook(response.book);
+        toast.success("Request sent. The owner will approve or decline it.");
     } catch (error: any) {
         console.error("Error requesting book:", error);
         if (error.response?.status === 404) {
             toast.error("Book not found or unavailable for request");
+        } else if (error.response?.status === 409) {
+            toast.error("You have already requested this book");
         } else {
             toast.error("Failed to request book");
         }
@@ -88,6 +88,19 @@ export default function BookDetailPage() {
     }
 };
 
+  const handleJoinWaitlist = async () => {
+    setRequestingBook(true);
+    try {
+        const { data } = await api.post(`/books/${id}/waitlist`, {});
+        toast.success(`You're number ${data.entry.position} on the waitlist. We'll email you when it's your turn.`);
+    } catch (error: any) {
+        console.error("Error joining waitlist:", error);
+        toast.error(error.response?.data?.error || "Failed to join waitlist");
+    } finally {
+        setRequestingBook(false);
+    }
+};
+
   if (isLoading) {
     return (
       <div className="flex justify-center items-center min-h-[70vh]">
@@ -114,7 +127,7 @@ export default function BookDetailPage() {
     )
   }
 
-  const statusLabel = book.status === "available" ? "Available" : "Currently Rented"
+  const statusLabel = bookStatusLabel(book.status)
 
   return (
     <div className="max-w-screen-xl mx-auto px-4">
@@ -175,8 +188,21 @@ export default function BookDetailPage() {
                 <div>
                   <p className="font-medium">Status</p>
                   <p className="text-muted-foreground">
-                    {book.status === "available" ? "Available for borrowing" : "Currently being borrowed"}
+                    {isRequestable(book.status) ? "Available for borrowing" : "Not available for borrowing right now"}
                   </p>
+                  {book.availability && book.availability.total > 1 && (
+                    <p className="text-sm text-muted-foreground">
+                      {book.availability.available} of {book.availability.total} copies available
+                    </p>
+                  )}
+                  {book.pricing && (
+                    <p className="text-sm text-muted-foreground">
+                      {formatMoney(book.pricing.price, book.pricing.currency)}
+                      {book.pricing.model === "per-day" ? " per day" : " per loan"}
+                      {book.pricing.deposit > 0 && `, ${formatMoney(book.pricing.deposit, book.pricing.currency)} deposit`}
+                      {book.pricing.lateFee > 0 && `, ${formatMoney(book.pricing.lateFee, book.pricing.currency)} per day late`}
+                    </p>
+                  )}
                 </div>
               </div>
               <div className="flex items-start">
@@ -203,7 +229,11 @@ export default function BookDetailPage() {
                 </div>
                 <div>
                   <p className="font-medium">Contact</p>
-                  <p className="text-muted-foreground">{formatPhoneNumber(book.contactInfo)}</p>
+                  <p className="text-muted-foreground">
+                    {book.contactInfo
+                      ? formatPhoneNumber(book.contactInfo)
+                      : "Shared once the owner accepts your rental"}
+                  </p>
                 </div>
               </div>
             </CardContent>
@@ -221,10 +251,18 @@ export default function BookDetailPage() {
                     Delete Book
                   </Button>
                 </div>
+              ) : isWaitlisted(book.status) ? (
+                <Button
+                  className="w-full py-2"
+                  disabled={requestingBook}
+                  onClick={handleJoinWaitlist}
+                >
+                  {requestingBook ? "Joining Waitlist..." : "Join Waitlist"}
+                </Button>
               ) : (
                 <Button
                   className="w-full py-2"
-                  disabled={book.status !== "available" || requestingBook}
+                  disabled={!isRequestable(book.status) || requestingBook}
                   onClick={handleRequestBook}
                 >
                   {requestingBook ? "Sending Request..." : "Request This Book"}
@@ -11,7 +11,7 @@ import { Input } from "@/components/ui/input"
 import { Label } from "@/components/ui/label"
 import { Select, SelectContent, SelectItem, SelectTrigger, SelectValue } from "@/components/ui/select"
 import { api } from "@/lib/api"
-import { BookFormData, Book } from "@/types"
+import { BookFormData, Book, OwnerBookStatus } from "@/types"
 import { toast } from "sonner"
 
 const genres = [
@@ -170,18 +170,36 @@ export default function EditBookPage({ params }: { params: { id: string } }) {
                 />
               </div>
               
+              <div className="space-y-2">
+                <Label htmlFor="loanPeriodDays">Loan Period in Days (Optional)</Label>
+                <Input
+                  id="loanPeriodDays"
+                  name="loanPeriodDays"
+                  type="number"
+                  min={1}
+                  max={365}
+                  placeholder="Leave empty for the default of 14 days"
+                  value={formData.loanPeriodDays ?? ""}
+                  onChange={(e) => setFormData((prev) => ({
+                    ...prev,
+                    loanPeriodDays: e.target.value === "" ? undefined : Number(e.target.value),
+                  }))}
+                />
+              </div>
+              
               <div className="space-y-2">
                 <Label htmlFor="status">Status</Label>
                 <Select
                   value={formData.status}
-                  onValueChange={(value) => handleSelectChange("status", value as "available" | "rented")}
+                  onValueChange={(value) => handleSelectChange("status", value as OwnerBookStatus)}
                 >
                   <SelectTrigger>
                     <SelectValue placeholder="Select availability status" />
                   </SelectTrigger>
                   <SelectContent>
                     <SelectItem value="available">Available</SelectItem>
-                    <SelectItem value="rented">Not Available</SelectItem>
+                    <SelectItem value="unavailable">Not Available</SelectItem>
+                    <SelectItem value="archived">Archived</SelectItem>
                   </SelectContent>
                 </Select>
               </div>
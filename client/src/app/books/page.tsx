@@ -10,7 +10,7 @@ import { Input } from "@/components/ui/input"
 import { Select, SelectContent, SelectItem, SelectTrigger, SelectValue } from "@/components/ui/select"
 import { api } from "@/lib/api"
 import { Book as BookType, BookSearchParams } from "@/types"
-import { truncateText, stringToColor } from "@/lib/utils"
+import { truncateText, stringToColor, bookStatusLabel } from "@/lib/utils"
 
 // Use constants for the "all" filter values
 const ALL_LOCATIONS = "all_locations"
@@ -249,7 +249,7 @@ export default function BooksPage() {
                         ? 'bg-green-100 text-green-800 dark:bg-green-900/30 dark:text-green-400' 
                         : 'bg-amber-100 text-amber-800 dark:bg-amber-900/30 dark:text-amber-400'
                     }`}>
-                      {book.status === 'available' ? 'Available' : 'Rented'}
+                      {bookStatusLabel(book.status)}
                     </span>
                   </CardFooter>
                 </Card>
@@ -2,19 +2,20 @@
 
 import { useEffect, useState } from "react";
 import { useRouter } from "next/navigation";
-import { Book } from "@/types";
+import { Book, Rental } from "@/types";
 import { Card, CardContent, CardDescription, CardFooter, CardHeader, CardTitle } from "@/components/ui/card";
 import { Button } from "@/components/ui/button";
 import { Tabs, TabsContent, TabsList, TabsTrigger } from "@/components/ui/tabs";
 import { Badge } from "@/components/ui/badge";
 import { Book as BookIcon, Edit, Trash2 } from "lucide-react";
 import { api } from "@/lib/api";
-import { filterBooksByStatus, truncateText } from "@/lib/utils";
+import { bookStatusLabel, filterBooksByStatus, filterBooksByStatuses, formatDate, truncateText } from "@/lib/utils";
 import { getCurrentUser } from "@/lib/auth";
 import { toast } from "sonner";
 
 export default function DashboardPage() {
   const [books, setBooks] = useState<Book[]>([]);
+  const [overdue, setOverdue] = useState<{ borrowed: Rental[]; lent: Rental[] }>({ borrowed: [], lent: [] });
   const [loading, setLoading] = useState(true);
   const router = useRouter();
   const user = getCurrentUser();
@@ -28,13 +29,13 @@ export default function DashboardPage() {
     const fetchBooks = async () => {
       try {
         setLoading(true);
-        const endpoint = user.role === "owner" ? "/books/owned" : "/rented-books";
+        const endpoint = user.roles.includes("owner") ? "/books/owned" : "/rented-books";
         const response: { books: Book[] } = await api.get(endpoint);
         setBooks(response.books || []);
       } catch (error: any) {
         console.error("Error fetching books:", error);
         if (error.message === "Book not found" || error.response?.status === 404) {
-          toast.info(user.role === "owner" ? "You haven't added any books yet." : "You haven't rented any books yet.");
+          toast.info(user.roles.includes("owner") ? "You haven't added any books yet." : "You haven't rented any books yet.");
         } else {
           toast.error("Failed to load books. Please try again later.");
         }
@@ -44,7 +45,17 @@ export default function DashboardPage() {
       }
     };
 
+    const fetchOverdue = async () => {
+      try {
+        const response: { borrowed: Rental[]; lent: Rental[] } = await api.get("/rentals/overdue");
+        setOverdue(response);
+      } catch (error) {
+        console.error("Error fetching overdue rentals:", error);
+      }
+    };
+
     fetchBooks();
+    fetchOverdue();
   }, [router, user]);
 
   const handleDeleteBook = async (bookId: string) => {
@@ -61,7 +72,7 @@ export default function DashboardPage() {
   };
 
   const availableBooks = filterBooksByStatus(books, "available");
-  const rentedBooks = filterBooksByStatus(books, "rented");
+  const rentedBooks = filterBooksByStatuses(books, ["checked-out", "return-pending"]);
 
   if (loading) {
     return (
@@ -78,13 +89,33 @@ export default function DashboardPage() {
     <div className="max-w-screen-xl mx-auto px-4">
       <div className="flex justify-between items-center mb-6">
         <h1 className="text-3xl font-bold">Your Dashboard</h1>
-        {user?.role === "owner" && (
+        {user?.roles.includes("owner") && (
           <Button onClick={() => router.push("/books/add")}>
             Add New Book
           </Button>
         )}
       </div>
 
+      {(overdue.borrowed.length > 0 || overdue.lent.length > 0) && (
+        <Card className="mb-6 border-red-300 dark:border-red-900">
+          <CardHeader className="pb-2">
+            <CardTitle className="text-red-700 dark:text-red-400">Overdue</CardTitle>
+          </CardHeader>
+          <CardContent className="space-y-1 text-sm">
+            {overdue.borrowed.map((rental) => (
+              <div key={rental.id}>
+                Please return <strong>{rental.bookTitle}</strong>, due {formatDate(rental.dueAt!)}
+              </div>
+            ))}
+            {overdue.lent.map((rental) => (
+              <div key={rental.id}>
+                <strong>{rental.bookTitle}</strong> was due back {formatDate(rental.dueAt!)}
+              </div>
+            ))}
+          </CardContent>
+        </Card>
+      )}
+
       <Tabs defaultValue="all" className="w-full">
         <TabsList className="mb-6">
           <TabsTrigger value="all">All Books ({books.length})</TabsTrigger>
@@ -95,7 +126,7 @@ export default function DashboardPage() {
         <TabsContent value="all">
           <BookList 
             books={books} 
-            userRole={user?.role || "seeker"} 
+            userRole={user?.roles.includes("owner") ? "owner" : "seeker"} 
             onDelete={handleDeleteBook} 
             onEdit={(id) => router.push(`/books/edit/${id}`)}
           />
@@ -104,7 +135,7 @@ export default function DashboardPage() {
         <TabsContent value="available">
           <BookList 
             books={availableBooks} 
-            userRole={user?.role || "seeker"} 
+            userRole={user?.roles.includes("owner") ? "owner" : "seeker"} 
             onDelete={handleDeleteBook} 
             onEdit={(id) => router.push(`/books/edit/${id}`)}
           />
@@ -113,7 +144,7 @@ export default function DashboardPage() {
         <TabsContent value="rented">
           <BookList 
             books={rentedBooks} 
-            userRole={user?.role || "seeker"} 
+            userRole={user?.roles.includes("owner") ? "owner" : "seeker"} 
             onDelete={handleDeleteBook} 
             onEdit={(id) => router.push(`/books/edit/${id}`)}
           />
@@ -171,7 +202,7 @@ function BookList({ books, userRole, onDelete, onEdit }: BookListProps) {
                 <CardDescription>by {book.author}</CardDescription>
               </div>
               <Badge variant={book.status === "available" ? "outline" : "secondary"}>
-                {book.status}
+                {bookStatusLabel(book.status)}
               </Badge>
             </div>
           </CardHeader>
@@ -29,6 +29,7 @@ export default function ProfilePage() {
   });
   
   const [passwordForm, setPasswordForm] = useState({
+    currentPassword: "",
     password: "",
     confirmPassword: ""
   });
@@ -128,8 +129,8 @@ export default function ProfilePage() {
       errors.email = "Please enter a valid email";
     }
     
-    if (profileForm.mobileNumber && profileForm.mobileNumber.length < 10) {
-      errors.mobileNumber = "Phone number must be at least 10 digits";
+    if (profileForm.mobileNumber && !/^\+[1-9]\d{1,14}$/.test(profileForm.mobileNumber.replace(/[\s().-]/g, ""))) {
+      errors.mobileNumber = "Use international format, such as +14155552671";
     }
     
     setProfileErrors(errors);
@@ -140,6 +141,10 @@ export default function ProfilePage() {
   const validatePasswordForm = (): boolean => {
     const errors: Record<string, string> = {};
     
+    if (!passwordForm.currentPassword) {
+      errors.currentPassword = "Current password is required";
+    }
+    
     if (!passwordForm.password || passwordForm.password.length < 8) {
       errors.password = "Password must be at least 8 characters";
     }
@@ -166,7 +171,6 @@ export default function ProfilePage() {
       setUpdating(true);
       const data: ProfileUpdateFormData = {
         name: profileForm.name,
-        email: profileForm.email,
         mobileNumber: profileForm.mobileNumber || undefined,
         address: profileForm.address || undefined
       };
@@ -193,10 +197,11 @@ export default function ProfilePage() {
     
     try {
       setUpdating(true);
-      await authService.updateProfile({ password: passwordForm.password });
+      await authService.changePassword(passwordForm.currentPassword, passwordForm.password);
       
       // Reset form
       setPasswordForm({
+        currentPassword: "",
         password: "",
         confirmPassword: ""
       });
@@ -244,8 +249,8 @@ export default function ProfilePage() {
             <CardContent>
               <div className="space-y-4">
                 <div>
-                  <p className="text-sm font-medium text-muted-foreground">Role</p>
-                  <p className="capitalize">{user?.role}</p>
+                  <p className="text-sm font-medium text-muted-foreground">Roles</p>
+                  <p className="capitalize">{user?.roles.join(", ")}</p>
                 </div>
                 {user?.mobileNumber && (
                   <div>
@@ -307,6 +312,7 @@ export default function ProfilePage() {
                         type="email"
                         value={profileForm.email}
                         onChange={handleProfileChange} 
+                        disabled
                         className={cn(
                           profileErrors.email && "border-destructive"
                         )}
@@ -377,6 +383,25 @@ export default function ProfilePage() {
                 </CardHeader>
                 <CardContent>
                   <form onSubmit={handleUpdatePassword} className="space-y-6">
+                    <div className="space-y-2">
+                      <Label htmlFor="currentPassword">Current Password</Label>
+                      <Input 
+                        id="currentPassword"
+                        name="currentPassword"
+                        type="password"
+                        value={passwordForm.currentPassword}
+                        onChange={handlePasswordChange}
+                        className={cn(
+                          passwordErrors.currentPassword && "border-destructive"
+                        )}
+                      />
+                      {passwordErrors.currentPassword && (
+                        <p className="text-sm text-destructive">
+                          {passwordErrors.currentPassword}
+                        </p>
+                      )}
+                    </div>
+
                     <div className="space-y-2">
                       <Label htmlFor="password">New Password</Label>
                       <Input 
@@ -12,6 +12,39 @@ const axiosInstance = axios.create({
   withCredentials: true, // Important for cookies/sessions
 });
 
+// CSRF token for the current session, required on state-changing requests
+let csrfToken: string | null = null;
+
+const SAFE_METHODS = ['get', 'head', 'options'];
+const SESSION_CHANGING_URLS = ['/login', '/logout'];
+
+axiosInstance.interceptors.request.use(async (config) => {
+  const method = (config.method || 'get').toLowerCase();
+  if (SAFE_METHODS.includes(method)) {
+    return config;
+  }
+  if (!csrfToken) {
+    try {
+      const response = await axiosInstance.get<{ csrfToken: string }>('/csrf-token');
+      csrfToken = response.data.csrfToken;
+    } catch {
+      // Not logged in yet; public routes don't need a token
+    }
+  }
+  if (csrfToken) {
+    config.headers.set('X-CSRF-Token', csrfToken);
+  }
+  return config;
+});
+
+axiosInstance.interceptors.response.use((response) => {
+  // A new or ended session gets a new token
+  if (SESSION_CHANGING_URLS.some((url) => response.config.url?.startsWith(url))) {
+    csrfToken = null;
+  }
+  return response;
+});
+
 // Generic request function with error handling
 const request = async <T>(
   method: string, 
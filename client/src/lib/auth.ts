@@ -59,6 +59,14 @@ export const authService = {
     setCurrentUser(response.user);
     return response.user;
   },
+
+  changePassword: async (currentPassword: string, newPassword: string): Promise<void> => {
+    await api.post<{ message: string }>('/me/password', { currentPassword, newPassword });
+  },
+
+  changeEmail: async (currentPassword: string, newEmail: string): Promise<void> => {
+    await api.post<{ message: string }>('/me/email', { currentPassword, newEmail });
+  },
 };
 
 export default authService;
\ No newline at end of file
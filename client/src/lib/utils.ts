@@ -57,6 +57,53 @@ export function filterBooksByStatus<T extends { status: string }>(
   return books.filter(book => book.status === status);
 }
 
+// Filter books by any of several statuses
+export function filterBooksByStatuses<T extends { status: string }>(
+  books: T[],
+  statuses: string[]
+): T[] {
+  return books.filter(book => statuses.includes(book.status));
+}
+
+// Human readable book status
+export function bookStatusLabel(status: string): string {
+  switch (status) {
+    case 'available':
+      return 'Available';
+    case 'requested':
+      return 'Requested';
+    case 'checked-out':
+      return 'Checked Out';
+    case 'return-pending':
+      return 'Return Pending';
+    case 'on-hold':
+      return 'On Hold';
+    case 'unavailable':
+      return 'Not Available';
+    case 'archived':
+      return 'Archived';
+  }
+  return status;
+}
+
+// Check if seekers can request a book with this status
+export function isRequestable(status: string): boolean {
+  return status === 'available' || status === 'requested';
+}
+
+// Check if seekers join the waitlist for a book with this status instead of
+// requesting it
+export function isWaitlisted(status: string): boolean {
+  return status === 'checked-out' || status === 'return-pending' || status === 'on-hold';
+}
+
+// Format an amount in the currency's minor units, such as cents, as money
+export function formatMoney(amount: number, currency: string): string {
+  const format = new Intl.NumberFormat(undefined, { style: 'currency', currency });
+  const digits = format.resolvedOptions().maximumFractionDigits ?? 2;
+  return format.format(amount / 10 ** digits);
+}
+
 // Check if user is owner of a book
 export function isBookOwner(bookOwnerId: string, userId?: string): boolean {
   return Boolean(userId && bookOwnerId === userId);
@@ -8,10 +8,32 @@ export type User = {
   password: string;
   mobileNumber: string;
   address: string;
-  role: "owner" | "seeker";
+  roles: ("owner" | "seeker" | "admin")[];
+  privacy?: {
+    email?: Visibility;
+    mobileNumber?: Visibility;
+    address?: Visibility;
+  };
 };
 
+// Who can see a contact detail; unset means "renters"
+export type Visibility = "public" | "renters" | "private";
+
 // Book related types
+// A book moves available → requested → checked-out → return-pending → available,
+// stopping at on-hold instead of available while it is offered to the waitlist
+export type BookStatus =
+  | "available"
+  | "requested"
+  | "checked-out"
+  | "return-pending"
+  | "on-hold"
+  | "unavailable"
+  | "archived";
+
+// Statuses an owner can set on their own book
+export type OwnerBookStatus = "available" | "unavailable" | "archived";
+
 export type Book = {
   coverColor: string;
   id: string;
@@ -21,8 +43,37 @@ export type Book = {
   location: string;
   contactInfo: string;
   ownerId: string;
-  status: "available" | "rented";
+  status: BookStatus; // the most available status of any copy
   imageUrl?: string;
+  loanPeriodDays?: number; // unset uses the site default
+  copies: BookCopy[];
+  availability?: Availability; // included in listings, search and book details
+  pricing?: Pricing; // unset when the book is lent for free
+};
+
+// What renting a book costs, in minor units of the currency (cents for USD)
+export type Pricing = {
+  currency: string;
+  model: "per-day" | "per-loan";
+  price: number;
+  deposit: number;
+  lateFee: number; // per full day overdue
+};
+
+export type CopyCondition = "new" | "like-new" | "good" | "fair" | "worn";
+
+// One physical copy of a book, lent on its own
+export type BookCopy = {
+  id: string;
+  condition?: CopyCondition;
+  status: BookStatus;
+  renterId?: string;
+};
+
+export type Availability = {
+  total: number;
+  available: number;
+  onLoan: number;
 };
 
 // API response types
@@ -66,8 +117,63 @@ export type BookFormData = {
   genre: string;
   location: string;
   contactInfo: string;
-  status: "available" | "rented";
+  status: BookStatus; // owners can only choose an OwnerBookStatus
   imageUrl?: string;
+  loanPeriodDays?: number;
+  pricing?: Pricing;
+};
+
+export type RentalEvent = {
+  type: string;
+  at: string;
+  actorId?: string;
+  note?: string;
+};
+
+export type Rental = {
+  id: string;
+  bookId: string;
+  copyId: string;
+  bookTitle: string;
+  ownerId: string;
+  renterId: string;
+  startedAt: string;
+  dueAt?: string;
+  returnedAt?: string;
+  outcome?: "returned" | "book-removed";
+  overdue?: boolean;
+  history: RentalEvent[];
+  extensions?: Extension[];
+  pricing?: Pricing; // the book's pricing when the rental started
+  lateFeesThrough?: string;
+  depositPaymentId?: string; // the payment provider's hold on the deposit
+};
+
+export type Balance = {
+  currency: string;
+  balance: number; // negative when the user owes money
+  held: number; // deposits held on active rentals
+};
+
+export type StatementLine = {
+  transactionId: string;
+  type: "rental-fee" | "extension-fee" | "late-fee" | "deposit-held" | "deposit-released";
+  rentalId?: string;
+  memo: string;
+  at: string;
+  amount: number;
+  balance: number;
+};
+
+export type Extension = {
+  id: string;
+  previousDueAt: string;
+  requestedDueAt: string;
+  status: "pending" | "approved" | "declined";
+  message?: string;
+  response?: string;
+  requestedAt: string;
+  decidedAt?: string;
 };
 
 export type ProfileUpdateFormData = {
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"nextchapter.com/m/models"
+)
+
+// runCommand runs an administrative command given on the command line
+func runCommand(args []string) {
+	switch args[0] {
+	case "promote-admin":
+		if len(args) != 2 {
+			log.Fatal("Usage: server promote-admin <email>")
+		}
+		if err := promoteAdmin(args[1]); err != nil {
+			log.Fatalf("Failed to promote admin: %v", err)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\nAvailable commands:\n  promote-admin <email>  give an existing user the admin role\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// promoteAdmin adds the admin role to the user with the given email. It is how
+// the first admin is created, since admins cannot be registered through the API.
+func promoteAdmin(email string) error {
+	models.InitializeDataStore()
+
+	user, found := models.GetUserByEmail(email)
+	if !found {
+		return fmt.Errorf("no user registered with email %s", email)
+	}
+
+	user.AddRole(models.RoleAdmin)
+	if err := models.SaveUser(user); err != nil {
+		return err
+	}
+
+	log.Printf("User %s (%s) is now an admin", user.Name, user.Email)
+	return nil
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime settings read from the environment
+type Config struct {
+	// AppURL is the base URL of the web client, used to build links in emails
+	AppURL string
+
+	// PasswordMinLength is the shortest password accepted
+	PasswordMinLength int
+	// PasswordBannedPatterns are regular expressions that passwords must not match
+	PasswordBannedPatterns []string
+	// BreachedPasswordsFile is a sorted list of SHA-1 hashes of known breached
+	// passwords. The check is skipped if the file does not exist.
+	BreachedPasswordsFile string
+
+	// OIDCProvidersFile lists the OpenID Connect identity providers users can sign in with
+	OIDCProvidersFile string
+
+	// WebAuthnRPID is the domain passkeys are bound to
+	WebAuthnRPID string
+	// WebAuthnRPName is the site name shown when creating a passkey
+	WebAuthnRPName string
+	// WebAuthnOrigins are the web origins allowed to use passkeys, defaulting to AppURL
+	WebAuthnOrigins []string
+
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header is believed when working out the client's IP.
+	// With none, the connection's address is used.
+	TrustedProxies []string
+
+	// SessionCookieName is the name of the cookie holding the session
+	SessionCookieName string
+	// SessionLifetime is how long a session lasts after login
+	SessionLifetime time.Duration
+	// ReauthWindow is how recently users without a password must have signed
+	// in to change security settings, in place of re-entering a password
+	ReauthWindow time.Duration
+	// CookieDomain is the Domain attribute of cookies, empty for host-only cookies
+	CookieDomain string
+	// CookieSecure restricts cookies to HTTPS, on by default when APP_URL uses https
+	CookieSecure bool
+	// CookieSameSite is the SameSite attribute of cookies
+	CookieSameSite http.SameSite
+	// CookieSigningKeys sign cookie values. The first key signs new cookies and
+	// every key is accepted, so keys can be rotated by adding a new key first
+	// and removing the old one once its cookies have expired.
+	CookieSigningKeys []SigningKey
+
+	// DefaultLoanDays is the loan period of books whose owner hasn't set one
+	DefaultLoanDays int
+	// ReminderInterval is how often rentals are checked for due dates
+	ReminderInterval time.Duration
+	// DueSoonReminder is how long before the due date renters are reminded
+	DueSoonReminder time.Duration
+	// OverdueReminderInterval is how often renters of overdue books are reminded
+	OverdueReminderInterval time.Duration
+	// MaxLoanExtensions is how many times a rental's due date can be extended
+	MaxLoanExtensions int
+	// WaitlistOfferWindow is how long the first seeker on a waitlist has to
+	// accept a returned book before it is offered to the next
+	WaitlistOfferWindow time.Duration
+	// DefaultCurrency is the currency of book prices whose owner didn't name one
+	DefaultCurrency string
+
+	// PaymentProvider collects rental deposits: "stripe", or "fake", which
+	// authorizes every payment without moving money and takes no webhooks. It
+	// has no default, so a deployment can't end up on the fake by accident.
+	PaymentProvider string
+	// StripeAPIURL is the base URL of the Stripe-style payment API
+	StripeAPIURL string
+	// StripeSecretKey authenticates requests to the payment API
+	StripeSecretKey string
+	// StripeWebhookSecret signs the webhooks the payment API sends
+	StripeWebhookSecret string
+}
+
+// SigningKey is a secret used to sign cookies, identified by ID in the cookie value
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+var current = Load()
+
+// Get returns the active configuration
+func Get() Config {
+	return current
+}
+
+// Set replaces the active configuration
+func Set(cfg Config) {
+	current = cfg
+}
+
+// Load reads the configuration from environment variables, falling back to defaults
+func Load() Config {
+	appURL := strings.TrimRight(getEnv("APP_URL", "http://localhost:3000"), "/")
+	return Config{
+		AppURL:                  appURL,
+		PasswordMinLength:       getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordBannedPatterns:  getEnvList("PASSWORD_BANNED_PATTERNS", []string{`(?i)password`, `(?i)qwerty`, `(?i)letmein`, `^(0?123456789?0?|987654321?0?)$`}),
+		BreachedPasswordsFile:   getEnv("BREACHED_PASSWORDS_FILE", "data/breached_passwords.txt"),
+		OIDCProvidersFile:       getEnv("OIDC_PROVIDERS_FILE", "data/oidc_providers.json"),
+		WebAuthnRPID:            getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:          getEnv("WEBAUTHN_RP_NAME", "NextChapter"),
+		WebAuthnOrigins:         getEnvList("WEBAUTHN_ORIGINS", []string{appURL}),
+		TrustedProxies:          getEnvList("TRUSTED_PROXIES", nil),
+		SessionCookieName:       getEnv("SESSION_COOKIE_NAME", "session"),
+		SessionLifetime:         getEnvDuration("SESSION_LIFETIME", 24*time.Hour),
+		ReauthWindow:            getEnvDuration("REAUTH_WINDOW", 10*time.Minute),
+		CookieDomain:            getEnv("COOKIE_DOMAIN", ""),
+		CookieSecure:            getEnvBool("COOKIE_SECURE", strings.HasPrefix(appURL, "https://")),
+		CookieSameSite:          getEnvSameSite("COOKIE_SAMESITE", http.SameSiteLaxMode),
+		CookieSigningKeys:       getEnvSigningKeys("COOKIE_SIGNING_KEYS"),
+		DefaultLoanDays:         getEnvInt("DEFAULT_LOAN_DAYS", 14),
+		ReminderInterval:        getEnvDuration("REMINDER_INTERVAL", time.Hour),
+		DueSoonReminder:         getEnvDuration("DUE_SOON_REMINDER", 48*time.Hour),
+		OverdueReminderInterval: getEnvDuration("OVERDUE_REMINDER_INTERVAL", 72*time.Hour),
+		MaxLoanExtensions:       getEnvInt("MAX_LOAN_EXTENSIONS", 2),
+		WaitlistOfferWindow:     getEnvDuration("WAITLIST_OFFER_WINDOW", 48*time.Hour),
+		DefaultCurrency:         strings.ToUpper(getEnv("DEFAULT_CURRENCY", "USD")),
+		PaymentProvider:         strings.ToLower(getEnv("PAYMENT_PROVIDER", "")),
+		StripeAPIURL:            getEnv("STRIPE_API_URL", "https://api.stripe.com"),
+		StripeSecretKey:         getEnv("STRIPE_SECRET_KEY", ""),
+		StripeWebhookSecret:     getEnv("STRIPE_WEBHOOK_SECRET", ""),
+	}
+}
+
+// getEnv returns the value of an environment variable or a fallback if it is unset
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt returns an integer environment variable or a fallback if it is unset or invalid
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvList returns a comma separated environment variable as a list, or a
+// fallback if it is unset
+func getEnvList(key string, fallback []string) []string {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvBool returns a boolean environment variable or a fallback if it is unset or invalid
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvDuration returns a duration such as "12h" from an environment variable,
+// or a fallback if it is unset or not positive
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// getEnvSameSite returns a SameSite mode named "lax", "strict" or "none", or a
+// fallback if it is unset or unknown
+func getEnvSameSite(key string, fallback http.SameSite) http.SameSite {
+	switch strings.ToLower(getEnv(key, "")) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return fallback
+}
+
+// getEnvSigningKeys reads a comma separated list of "id:secret" signing keys.
+// A key without an ID is given its position in the list.
+func getEnvSigningKeys(key string) []SigningKey {
+	keys := make([]SigningKey, 0)
+	for i, item := range getEnvList(key, nil) {
+		id, secret, found := strings.Cut(item, ":")
+		if !found {
+			id, secret = strconv.Itoa(i), item
+		}
+		if secret != "" {
+			keys = append(keys, SigningKey{ID: id, Secret: []byte(secret)})
+		}
+	}
+	return keys
+}
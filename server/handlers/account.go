@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/mailer"
+	"nextchapter.com/m/middleware"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/password"
+	"nextchapter.com/m/validation"
+)
+
+// ChangePassword sets a new password for the current user after checking the
+// current one, or sets a first password for an account without one. All of
+// the user's other sessions are signed out.
+func ChangePassword(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	if !confirmCurrentPassword(c, user, body.CurrentPassword) {
+		return
+	}
+	if errs := password.Check("newPassword", body.NewPassword, user.Name, user.Email); len(errs) > 0 {
+		respondFieldErrors(c, "Password does not meet requirements", errs)
+		return
+	}
+
+	user.Password = body.NewPassword
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	middleware.RemoveUserSessions(user.ID, c.GetString("sessionID"))
+	notifyAccountChange(user.Email, "Your password was changed",
+		fmt.Sprintf("Hi %s,\n\nThe password for your NextChapter account was just changed and your other sessions were signed out.\n\nIf this wasn't you, reset your password and contact support right away.%s", user.Name, passkeyNotice(user.ID)))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated successfully"})
+}
+
+// ChangeEmail starts an email change for the current user after checking the
+// current password. The new address takes effect once confirmed through the
+// emailed link, and all of the user's other sessions are signed out.
+func ChangeEmail(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewEmail        string `json:"newEmail" binding:"required,email,max=254"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	if !confirmCurrentPassword(c, user, body.CurrentPassword) {
+		return
+	}
+	if body.NewEmail == user.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This is already your email"})
+		return
+	}
+	if _, found := models.GetUserByEmail(body.NewEmail); found {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already in use"})
+		return
+	}
+
+	user.PendingEmail = body.NewEmail
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+	if err := sendVerificationEmail(user, body.NewEmail); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	middleware.RemoveUserSessions(user.ID, c.GetString("sessionID"))
+	notifyAccountChange(user.Email, "Your email is being changed",
+		fmt.Sprintf("Hi %s,\n\nA change of the email for your NextChapter account to %s was requested and your other sessions were signed out. The change takes effect once the new address is confirmed.\n\nIf this wasn't you, change your password and contact support right away.%s", user.Name, body.NewEmail, passkeyNotice(user.ID)))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Check your new email address to confirm the change"})
+}
+
+// confirmCurrentPassword checks the password the user re-entered, writing an
+// error response if it is wrong. Wrong guesses count towards the login lockout
+// so a stolen session cannot be used to guess the password. Accounts without a
+// password, which sign in with a magic link, single sign-on or a passkey,
+// confirm by having signed in recently instead.
+func confirmCurrentPassword(c *gin.Context, user models.User, current string) bool {
+	if user.Password == "" {
+		return confirmRecentLogin(c)
+	}
+	if loginLockedOut(c, user.Email) {
+		return false
+	}
+	if user.Password != current {
+		recordLoginFailure(c, user.Email, user.ID, "wrong current password")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return false
+	}
+	return true
+}
+
+// confirmRecentLogin writes an error response unless the current session was
+// signed in to within the re-authentication window. Users re-authenticate by
+// signing in again with a magic link, their identity provider or a passkey.
+func confirmRecentLogin(c *gin.Context) bool {
+	startedAt, found := middleware.SessionStartedAt(c.GetString("sessionID"))
+	if found && time.Since(startedAt) <= config.Get().ReauthWindow {
+		return true
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in again to confirm this change", "reauthRequired": true})
+	return false
+}
+
+// notifyAccountChange emails the user about a security sensitive change to
+// their account. Delivery failures are logged, the change itself stands.
+func notifyAccountChange(to, subject, body string) {
+	if err := mailer.Send(to, subject, body); err != nil {
+		log.Printf("Failed to send account change notification to %s: %v", to, err)
+	}
+}
@@ -3,18 +3,25 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
 	"nextchapter.com/m/middleware"
 	"nextchapter.com/m/models"
+	"nextchapter.com/m/validation"
 )
 
 // RegisterUser handles user registration
 func RegisterUser(c *gin.Context) {
 	var user models.User
 	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
 		return
 	}
 
@@ -32,8 +39,8 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
-	// Don't return the password in the response
-	user.Password = ""
+	// Don't return the password or 2FA secrets in the response
+	user = user.WithoutSecrets()
 	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully", "user": user})
 }
 
@@ -45,48 +52,164 @@ func Login(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&credentials); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	// Refuse to check the password while the IP or account is locked out
+	if loginLockedOut(c, credentials.Email) {
 		return
 	}
 
 	// Find the user
 	user, found := models.GetUserByEmail(credentials.Email)
 	if !found {
+		recordLoginFailure(c, credentials.Email, "", "unknown email")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	// Compare passwords directly (no hashing)
 	if user.Password != credentials.Password {
+		recordLoginFailure(c, credentials.Email, user.ID, "wrong password")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	// Generate session ID
-	sessionID, err := generateSessionID()
+	completeLogin(c, user)
+}
+
+// loginLockedOut writes a 429 response with a Retry-After header if the
+// client's IP or the account is temporarily locked out after failed logins.
+// Refused attempts aren't recorded, so a client hammering a locked account
+// can't make the server rewrite the attempt log on every request.
+func loginLockedOut(c *gin.Context, email string) bool {
+	wait := middleware.LoginRetryAfter(c.ClientIP(), email)
+	if wait <= 0 {
+		return false
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      fmt.Sprintf("Too many failed login attempts. Try again in %d seconds", seconds),
+		"retryAfter": seconds,
+	})
+	return true
+}
+
+// recordLoginFailure counts a failed login towards the lockout and keeps it for admin review
+func recordLoginFailure(c *gin.Context, email, userID, reason string) {
+	middleware.RecordLoginFailure(c.ClientIP(), email)
+	if err := models.RecordLoginAttempt(models.LoginAttempt{
+		Email:  email,
+		IP:     c.ClientIP(),
+		UserID: userID,
+		Reason: reason,
+		At:     time.Now(),
+	}); err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+	}
+}
+
+// completeLogin finishes a login once the user's primary credentials have been
+// checked. Users with two-factor authentication enabled get a pending login
+// token to exchange for a session in VerifyTwoFactorLogin; everyone else gets
+// a session straight away.
+func completeLogin(c *gin.Context, user models.User) {
+	token, ok := beginLogin(c, user)
+	if !ok {
+		return
+	}
+	if token != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"message":           "Two-factor authentication required",
+			"twoFactorRequired": true,
+			"pendingToken":      token,
+		})
+		return
+	}
+
+	startSession(c, user)
+}
+
+// beginLogin runs the checks every login goes through once the user's primary
+// credentials have been checked. It returns a pending login token if the user
+// still has to enter a second factor, and false if it wrote an error response.
+func beginLogin(c *gin.Context, user models.User) (string, bool) {
+	if loginSuspended(c, user) {
+		return "", false
+	}
+	if !user.TOTPEnabled {
+		return "", true
+	}
+	token, err := createPendingLogin(user.ID)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+	return token, true
+}
+
+// startSession creates a session for the user, sets the session cookie and
+// writes the login response
+func startSession(c *gin.Context, user models.User) {
+	if loginSuspended(c, user) {
+		return
+	}
+	if err := createSession(c, user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
+	// Don't return the password or 2FA secrets in the response
+	user = user.WithoutSecrets()
+	c.JSON(http.StatusOK, gin.H{"message": "Login successful", "user": user})
+}
+
+// loginSuspended writes a 403 response explaining the suspension if the
+// user's account is suspended. It is checked only after the user has proven
+// who they are, so it doesn't reveal anything about other accounts.
+func loginSuspended(c *gin.Context, user models.User) bool {
+	if !user.IsSuspended(time.Now()) {
+		return false
+	}
+	if err := models.RecordLoginAttempt(models.LoginAttempt{
+		Email:  user.Email,
+		IP:     c.ClientIP(),
+		UserID: user.ID,
+		Reason: "suspended",
+		At:     time.Now(),
+	}); err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": middleware.SuspensionMessage(user), "suspended": true})
+	return true
+}
+
+// createSession stores a new session for the user and sets the session cookie
+func createSession(c *gin.Context, user models.User) error {
+	// Generate session ID
+	sessionID, err := generateSessionID()
+	if err != nil {
+		return err
+	}
+
 	// Store session using the middleware function
 	middleware.SetSession(sessionID, user.ID)
+	middleware.RecordLoginSuccess(user.Email)
 
 	// Return session cookie
-	c.SetCookie("session", sessionID, 3600*24, "/", "", false, true)
-
-	// Don't return the password in the response
-	user.Password = ""
-	c.JSON(http.StatusOK, gin.H{"message": "Login successful", "user": user})
+	middleware.SetSessionCookie(c, sessionID)
+	return nil
 }
 
 // Logout handles user logout
 func Logout(c *gin.Context) {
-	sessionID, err := c.Cookie("session")
-	if err == nil {
+	if sessionID, found := middleware.ReadCookie(c, config.Get().SessionCookieName); found {
 		middleware.RemoveSession(sessionID)
 	}
-	c.SetCookie("session", "", -1, "/", "", false, true)
+	middleware.ClearSessionCookie(c)
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
@@ -99,11 +222,22 @@ func GetCurrentUser(c *gin.Context) {
 	}
 
 	userData := user.(models.User)
-	userData.Password = "" // Don't return the password
+	userData = userData.WithoutSecrets() // Don't return the password or 2FA secrets
 
 	c.JSON(http.StatusOK, gin.H{"user": userData})
 }
 
+// GetCSRFToken returns the CSRF token that cookie-authenticated clients must
+// send in the X-CSRF-Token header on state-changing requests
+func GetCSRFToken(c *gin.Context) {
+	token, err := middleware.CSRFToken(c.GetString("sessionID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create CSRF token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"csrfToken": token})
+}
+
 // generateSessionID generates a random session ID
 func generateSessionID() (string, error) {
 	b := make([]byte, 32)
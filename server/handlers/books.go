@@ -3,11 +3,16 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"nextchapter.com/m/models"
+	"nextchapter.com/m/policy"
+	"nextchapter.com/m/validation"
 )
 
 // CreateBook handles the creation of a new book listing
@@ -20,16 +25,10 @@ func CreateBook(c *gin.Context) {
 	}
 	user := userObj.(models.User)
 
-	// Only owners can create book listings
-	if user.Role != models.RoleOwner {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only book owners can create listings"})
-		return
-	}
-
 	// Bind the book data from request
 	var book models.Book
 	if err := c.ShouldBindJSON(&book); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
 		return
 	}
 
@@ -46,7 +45,28 @@ func CreateBook(c *gin.Context) {
 
 	// Set initial status if not provided
 	if book.Status == "" {
-		book.Status = "available"
+		book.Status = models.BookAvailable
+	}
+	normalizePricing(book.Pricing)
+
+	errs := validation.Struct(book)
+	if book.Status != models.BookAvailable && book.Status != models.BookUnavailable {
+		errs = append(errs, validation.FieldError{Field: "status", Code: "invalid_choice", Message: "New books must be available or unavailable"})
+	}
+	if len(errs) > 0 {
+		respondFieldErrors(c, "Please correct the highlighted fields", errs)
+		return
+	}
+
+	// Every copy starts with the book's status. Only the conditions are taken
+	// from the request, and a listing without copies gets one.
+	copies := book.Copies
+	if len(copies) == 0 {
+		copies = []models.BookCopy{{}}
+	}
+	book.Copies = nil
+	for _, bookCopy := range copies {
+		book.AddCopy(bookCopy.Condition, book.Status)
 	}
 
 	// Save the book
@@ -58,21 +78,56 @@ func CreateBook(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"message": "Book created successfully", "book": book})
 }
 
-// GetAllBooks returns all available books
+// GetAllBooks returns all available books, with how many of their copies are
+// available
 func GetAllBooks(c *gin.Context) {
-	books := models.GetAllBooks()
-	c.JSON(http.StatusOK, gin.H{"books": books})
+	books := redactBooks(viewer(c), listedBooks(models.GetAllBooks()))
+	c.JSON(http.StatusOK, gin.H{"books": bookListings(books)})
 }
 
 // GetBook returns a specific book by ID
 func GetBook(c *gin.Context) {
 	id := c.Param("id")
 	book, exists := models.GetBookByID(id)
-	if !exists {
+	if !exists || ownerSuspended(book) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"book": book})
+	c.JSON(http.StatusOK, gin.H{"book": bookListing{redactBook(viewer(c), book), book.Availability()}})
+}
+
+// bookListing is a book as listed publicly, with counts of its copies
+type bookListing struct {
+	models.Book
+	Availability models.Availability `json:"availability"`
+}
+
+// bookListings adds the copy counts to each book in a list
+func bookListings(books []models.Book) []bookListing {
+	listings := make([]bookListing, 0, len(books))
+	for _, book := range books {
+		listings = append(listings, bookListing{book, book.Availability()})
+	}
+	return listings
+}
+
+// listedBooks drops archived books and the books of suspended owners from a
+// public listing
+func listedBooks(books []models.Book) []models.Book {
+	suspended := models.GetSuspendedUserIDs(time.Now())
+	listed := make([]models.Book, 0, len(books))
+	for _, book := range books {
+		if models.IsListed(book.Status) && !suspended[book.OwnerID] {
+			listed = append(listed, book)
+		}
+	}
+	return listed
+}
+
+// ownerSuspended reports whether the book's owner is suspended
+func ownerSuspended(book models.Book) bool {
+	owner, found := models.GetUserByID(book.OwnerID)
+	return found && owner.IsSuspended(time.Now())
 }
 
 // UpdateBook updates a book listing
@@ -88,6 +143,9 @@ func UpdateBook(c *gin.Context) {
 	// Get book ID from URL
 	id := c.Param("id")
 
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
 	// Check if book exists
 	existingBook, exists := models.GetBookByID(id)
 	if !exists {
@@ -95,33 +153,55 @@ func UpdateBook(c *gin.Context) {
 		return
 	}
 
-	// Check if user is the owner
-	if existingBook.OwnerID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You can only update your own books"})
+	// Check if user may update this book
+	if err := policy.Check(user, policy.UpdateBook, existingBook); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Bind updated book data
 	var updatedBook models.Book
 	if err := c.ShouldBindJSON(&updatedBook); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
 		return
 	}
 
-	// Keep the same ID and owner
+	// Keep the same ID, owner and copies; copies are managed on their own
 	updatedBook.ID = id
 	updatedBook.OwnerID = user.ID
+	updatedBook.Copies = existingBook.Copies
+	if updatedBook.Status == "" {
+		updatedBook.Status = existingBook.Status
+	}
+	normalizePricing(updatedBook.Pricing)
+
+	if errs := validation.Struct(updatedBook); len(errs) > 0 {
+		respondFieldErrors(c, "Please correct the highlighted fields", errs)
+		return
+	}
+	if updatedBook.Status != existingBook.Status {
+		if denied := setCopiesStatus(&updatedBook, updatedBook.Status); denied != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": denied})
+			return
+		}
+	}
 
 	// Update the book
-	if err := models.UpdateBook(updatedBook, user.ID); err != nil {
+	if err := models.UpdateBook(updatedBook); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if err := offerFreeCopies(&updatedBook, time.Now()); err != nil {
+		log.Printf("Failed to offer copies of book %s to its waitlist: %v", id, err)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Book updated successfully", "book": updatedBook})
 }
 
-// DeleteBook removes a book listing
+// DeleteBook removes a book listing. Books with a copy out on loan, or back
+// with the owner waiting for them to confirm the return, can't be deleted
+// until the return is confirmed, so rentals always end with the handshake.
+// Its waitlist is closed and the requests still waiting on it are declined.
 func DeleteBook(c *gin.Context) {
 	// Get the current user from the context
 	userObj, exists := c.Get("user")
@@ -134,12 +214,39 @@ func DeleteBook(c *gin.Context) {
 	// Get book ID from URL
 	id := c.Param("id")
 
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	// Check if book exists
+	book, exists := models.GetBookByID(id)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+
+	// Check if user may delete this book
+	if err := policy.Check(user, policy.DeleteBook, book); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, bookCopy := range book.Copies {
+		if bookCopy.Status == models.BookCheckedOut || bookCopy.Status == models.BookReturnPending {
+			c.JSON(http.StatusConflict, gin.H{"error": "A copy of this book is out on loan. Confirm its return before deleting the book"})
+			return
+		}
+	}
+
 	// Delete the book
-	if err := models.DeleteBook(id, user.ID); err != nil {
+	if err := models.DeleteBook(id); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	now := time.Now()
+	closeBookWaitlist(book, now)
+	declineBookRequests(book, now)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Book deleted successfully"})
 }
 
@@ -158,7 +265,7 @@ func GetMyBooks(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"books": books})
 }
 
-// UpdateBookStatus updates just the status of a book
+// UpdateBookStatus updates just the status of a book, moving every copy to it
 func UpdateBookStatus(c *gin.Context) {
 	userObj, exists := c.Get("user")
 	if !exists {
@@ -167,6 +274,9 @@ func UpdateBookStatus(c *gin.Context) {
 	}
 	user := userObj.(models.User)
 
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
 	id := c.Param("id")
 	existingBook, exists := models.GetBookByID(id)
 	if !exists {
@@ -174,32 +284,83 @@ func UpdateBookStatus(c *gin.Context) {
 		return
 	}
 
-	if existingBook.OwnerID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You can only update your own books"})
+	if err := policy.Check(user, policy.UpdateBookStatus, existingBook); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Owners can only take a book off the market and back; the other
+	// statuses follow rental requests and returns
 	var statusData struct {
-		Status string `json:"status" binding:"required"`
+		Status string `json:"status" binding:"required,oneof=available unavailable archived"`
 	}
 	if err := c.ShouldBindJSON(&statusData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
 		return
 	}
 
-	existingBook.Status = statusData.Status
-	if statusData.Status == "available" {
-		existingBook.RenterID = ""
+	if denied := setCopiesStatus(&existingBook, statusData.Status); denied != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": denied})
+		return
 	}
 
-	if err := models.UpdateBook(existingBook, user.ID); err != nil {
+	if err := models.UpdateBook(existingBook); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if err := offerFreeCopies(&existingBook, time.Now()); err != nil {
+		log.Printf("Failed to offer copies of book %s to its waitlist: %v", id, err)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Book status updated successfully", "book": existingBook})
 }
 
+// setCopiesStatus moves every copy of the owner's book to the status, or
+// explains why it can't, changing nothing. The caller saves the book.
+func setCopiesStatus(book *models.Book, to string) string {
+	for _, bookCopy := range book.Copies {
+		if denied := statusChangeDenied(bookCopy.Status, to); denied != "" {
+			return denied
+		}
+	}
+	for _, bookCopy := range book.Copies {
+		book.SetCopyStatus(bookCopy.ID, to)
+	}
+	return ""
+}
+
+// statusChangeDenied explains why an owner may not move a copy of their book
+// from one status to the other, or returns "" if they may. Keeping the same
+// status is always fine.
+func statusChangeDenied(from, to string) string {
+	if from == to || models.OwnerCanSetBookStatus(from, to) {
+		return ""
+	}
+	switch from {
+	case models.BookRequested:
+		return "This book has pending requests. Approve or decline them before making it " + to
+	case models.BookCheckedOut, models.BookReturnPending:
+		return "This book is on loan. It becomes available again once you confirm its return"
+	case models.BookOnHold:
+		return "This book is being held for the next reader on its waitlist"
+	}
+	return fmt.Sprintf("A book that is %s can't be made %s", from, to)
+}
+
+// transitionCopy moves a copy of a book to a new status as part of the rental
+// workflow, and saves the book
+func transitionCopy(book *models.Book, copyID, to string) error {
+	bookCopy := book.Copy(copyID)
+	if bookCopy == nil {
+		return fmt.Errorf("book %s has no copy %s", book.ID, copyID)
+	}
+	if !models.CanTransitionBook(bookCopy.Status, to) {
+		return fmt.Errorf("copy %s of book %s can't move from %s to %s", copyID, book.ID, bookCopy.Status, to)
+	}
+	book.SetCopyStatus(copyID, to)
+	return models.UpdateBook(*book)
+}
+
 // SearchBooks searches for books by title, author, or location
 func SearchBooks(c *gin.Context) {
 	query := c.Query("q")
@@ -211,7 +372,7 @@ func SearchBooks(c *gin.Context) {
 		return
 	}
 
-	allBooks := models.GetAllBooks()
+	allBooks := listedBooks(models.GetAllBooks())
 	filteredBooks := []models.Book{}
 
 	for _, book := range allBooks {
@@ -225,7 +386,7 @@ func SearchBooks(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"books": filteredBooks})
+	c.JSON(http.StatusOK, gin.H{"books": bookListings(redactBooks(viewer(c), filteredBooks))})
 }
 
 // Helper function to check if a string contains another string (case insensitive)
@@ -253,17 +414,12 @@ func GetOwnedBooks(c *gin.Context) {
 	}
 	user := userObj.(models.User)
 
-	// Restrict access to owners only
-	if user.Role != models.RoleOwner {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can access this endpoint"})
-		return
-	}
-
 	books := models.GetBooksByOwner(user.ID)
 	c.JSON(http.StatusOK, gin.H{"books": books})
 }
 
-// GetRentedBooks returns all books rented by the current user (for seekers)
+// GetRentedBooks returns the books the current user has out on active
+// rentals (for seekers)
 func GetRentedBooks(c *gin.Context) {
 	userObj, exists := c.Get("user")
 	if !exists {
@@ -272,48 +428,17 @@ func GetRentedBooks(c *gin.Context) {
 	}
 	user := userObj.(models.User)
 
-	// Restrict access to seekers only
-	if user.Role != models.RoleSeeker {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only seekers can access this endpoint"})
-		return
-	}
-
-	books := models.GetBooksByRenter(user.ID)
-	c.JSON(http.StatusOK, gin.H{"books": books})
-}
-func RequestBook(c *gin.Context) {
-	userObj, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
-		return
-	}
-	user := userObj.(models.User)
-
-	// Restrict to seekers only
-	if user.Role != models.RoleSeeker {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only seekers can request books"})
-		return
-	}
-
-	id := c.Param("id")
-	book, exists := models.GetBookByID(id)
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
-		return
-	}
-
-	if book.Status != "available" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Book is not available for rent"})
-		return
-	}
-
-	book.Status = "rented"
-	book.RenterID = user.ID
-
-	if err := models.UpdateBook(book, book.OwnerID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
-		return
+	// Renting several copies of a book lists it once
+	books := make([]models.Book, 0)
+	seen := make(map[string]bool)
+	for _, rental := range models.GetRentalsByRenter(user.ID) {
+		if !rental.Active() || seen[rental.BookID] {
+			continue
+		}
+		if book, exists := models.GetBookByID(rental.BookID); exists {
+			seen[book.ID] = true
+			books = append(books, book)
+		}
 	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Book requested successfully", "book": book})
+	c.JSON(http.StatusOK, gin.H{"books": redactBooks(user, books)})
 }
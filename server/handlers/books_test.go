@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/models"
+)
+
+func TestDeleteBookRefusedWhileOnLoan(t *testing.T) {
+	useTempDataDir(t)
+	gin.SetMode(gin.TestMode)
+	owner := saveTestUser(t, models.User{Name: "Owner", Email: "keeper@example.com", EmailVerified: true})
+	renter := saveTestUser(t, models.User{Name: "Renter", Email: "tenant@example.com", EmailVerified: true})
+	router := gin.New()
+	router.DELETE("/api/books/:id", func(c *gin.Context) { c.Set("user", owner) }, DeleteBook)
+
+	for _, status := range []string{models.BookCheckedOut, models.BookReturnPending} {
+		book, rental := saveTestRental(t, owner, renter, status)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/books/"+book.ID, nil))
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("deleting a %s book answered %d, want %d", status, rec.Code, http.StatusConflict)
+		}
+		if _, exists := models.GetBookByID(book.ID); !exists {
+			t.Errorf("%s book was deleted", status)
+		}
+		if r, _ := models.GetRentalByID(rental.ID); !r.Active() {
+			t.Errorf("rental of the %s book was ended: %+v", status, r)
+		}
+	}
+}
+
+func TestBookListingShowsRenterOnlyToOwnerAndRenter(t *testing.T) {
+	useTempDataDir(t)
+	gin.SetMode(gin.TestMode)
+	owner := saveTestUser(t, models.User{Name: "Owner", Email: "shelf-owner@example.com", EmailVerified: true})
+	renter := saveTestUser(t, models.User{Name: "Renter", Email: "shelf-renter@example.com", EmailVerified: true})
+	stranger := saveTestUser(t, models.User{Name: "Stranger", Email: "shelf-stranger@example.com", EmailVerified: true})
+	book, _ := saveTestRental(t, owner, renter, models.BookCheckedOut)
+
+	for name, tc := range map[string]struct {
+		viewer     *models.User
+		seesRenter bool
+	}{
+		"anonymous": {nil, false},
+		"stranger":  {&stranger, false},
+		"renter":    {&renter, true},
+		"owner":     {&owner, true},
+	} {
+		router := gin.New()
+		router.GET("/api/books/:id", func(c *gin.Context) {
+			if tc.viewer != nil {
+				c.Set("user", *tc.viewer)
+			}
+		}, GetBook)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/books/"+book.ID, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: fetching the book answered %d", name, rec.Code)
+		}
+		var body struct {
+			Book models.Book `json:"book"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if seen := body.Book.Copies[0].RenterID == renter.ID; seen != tc.seesRenter {
+			t.Errorf("%s sees the renter: %v, want %v", name, seen, tc.seesRenter)
+		}
+	}
+	if stored, _ := models.GetBookByID(book.ID); !stored.RentedBy(renter.ID) {
+		t.Error("redacting the listing cleared the stored copy's renter")
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/policy"
+	"nextchapter.com/m/validation"
+)
+
+// AddBookCopy adds another physical copy to one of the owner's books. An
+// available copy goes straight to the first seeker on the waitlist, if any.
+func AddBookCopy(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		Condition string `json:"condition" binding:"omitempty,oneof=new like-new good fair worn"`
+		Status    string `json:"status" binding:"omitempty,oneof=available unavailable"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+	if body.Status == "" {
+		body.Status = models.BookAvailable
+	}
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	book, ok := loadOwnedBook(c, user)
+	if !ok {
+		return
+	}
+	if len(book.Copies) >= 50 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A book can have at most 50 copies"})
+		return
+	}
+
+	bookCopy := book.AddCopy(body.Condition, body.Status)
+	if err := models.UpdateBook(book); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
+		return
+	}
+	if err := offerFreeCopies(&book, time.Now()); err != nil {
+		log.Printf("Failed to offer copies of book %s to its waitlist: %v", book.ID, err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Copy added", "book": book, "copy": book.Copy(bookCopy.ID)})
+}
+
+// UpdateBookCopy changes the condition of one of the owner's copies
+func UpdateBookCopy(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		Condition string `json:"condition" binding:"omitempty,oneof=new like-new good fair worn"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	book, bookCopy, ok := loadOwnedCopy(c, user)
+	if !ok {
+		return
+	}
+	bookCopy.Condition = body.Condition
+	if err := models.UpdateBook(book); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Copy updated", "book": book, "copy": bookCopy})
+}
+
+// UpdateCopyStatus takes one of the owner's copies off the market or puts it
+// back, like UpdateBookStatus does for every copy
+func UpdateCopyStatus(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		Status string `json:"status" binding:"required,oneof=available unavailable archived"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	book, bookCopy, ok := loadOwnedCopy(c, user)
+	if !ok {
+		return
+	}
+	if denied := statusChangeDenied(bookCopy.Status, body.Status); denied != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": denied})
+		return
+	}
+	book.SetCopyStatus(bookCopy.ID, body.Status)
+	if err := models.UpdateBook(book); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
+		return
+	}
+	if err := offerFreeCopies(&book, time.Now()); err != nil {
+		log.Printf("Failed to offer copies of book %s to its waitlist: %v", book.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Copy status updated", "book": book, "copy": book.Copy(bookCopy.ID)})
+}
+
+// RemoveBookCopy deletes one of the owner's copies. Copies that are requested,
+// lent or held for a seeker can't be removed, nor can a book's last copy.
+func RemoveBookCopy(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	book, bookCopy, ok := loadOwnedCopy(c, user)
+	if !ok {
+		return
+	}
+	switch bookCopy.Status {
+	case models.BookAvailable, models.BookUnavailable, models.BookArchived:
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "This copy is " + bookCopy.Status + " and can't be removed until it is back with you"})
+		return
+	}
+	if len(book.Copies) == 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This is the book's only copy. Delete the book instead"})
+		return
+	}
+
+	book.RemoveCopy(bookCopy.ID)
+	if err := models.UpdateBook(book); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Copy removed", "book": book})
+}
+
+// loadOwnedBook loads the book named in the URL, checking the user may manage
+// it. It writes the error response and returns false if not.
+func loadOwnedBook(c *gin.Context, user models.User) (models.Book, bool) {
+	book, exists := models.GetBookByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return models.Book{}, false
+	}
+	if err := policy.Check(user, policy.UpdateBook, book); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return models.Book{}, false
+	}
+	return book, true
+}
+
+// loadOwnedCopy is loadOwnedBook for the copy named in the URL. The copy
+// points into the returned book.
+func loadOwnedCopy(c *gin.Context, user models.User) (models.Book, *models.BookCopy, bool) {
+	book, ok := loadOwnedBook(c, user)
+	if !ok {
+		return models.Book{}, nil, false
+	}
+	bookCopy := book.Copy(c.Param("copyId"))
+	if bookCopy == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Copy not found"})
+		return models.Book{}, nil, false
+	}
+	return book, bookCopy, true
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/policy"
+	"nextchapter.com/m/validation"
+)
+
+// RequestExtension asks the owner for a later due date. A rental can be
+// extended a limited number of times, and not while other seekers are
+// waiting for the book.
+func RequestExtension(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		DueAt   string `json:"dueAt" binding:"required"`
+		Message string `json:"message" binding:"max=500"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	rental, book, ok := loadActiveRental(c, user, policy.ExtendRental)
+	if !ok {
+		return
+	}
+	if book.Copy(rental.CopyID).Status != models.BookCheckedOut || rental.DueAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "This rental can't be extended"})
+		return
+	}
+	if rental.PendingExtension() >= 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already asked for an extension"})
+		return
+	}
+	if denied := extensionDenied(rental); denied != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": denied})
+		return
+	}
+
+	dueAt, valid := parseDueDate(body.DueAt, *rental.DueAt)
+	if !valid {
+		respondFieldErrors(c, "Please correct the highlighted fields", []validation.FieldError{{Field: "dueAt", Code: "invalid_date", Message: "Must be a date such as 2025-06-30"}})
+		return
+	}
+	// Extensions are limited to one more loan period
+	now := time.Now()
+	latest := rental.DueAt.AddDate(0, 0, loanPeriodDays(book))
+	if !dueAt.After(*rental.DueAt) || !dueAt.After(now) || dueAt.After(latest) {
+		respondFieldErrors(c, "Please correct the highlighted fields", []validation.FieldError{{
+			Field:   "dueAt",
+			Code:    "out_of_range",
+			Message: fmt.Sprintf("Must be after the current due date and no later than %s", latest.Format("2006-01-02")),
+		}})
+		return
+	}
+
+	id, err := generateID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate ID"})
+		return
+	}
+	message := strings.TrimSpace(body.Message)
+	rental, err = models.UpdateRental(rental.ID, func(r *models.Rental) {
+		r.Extensions = append(r.Extensions, models.Extension{
+			ID:             id,
+			PreviousDueAt:  *r.DueAt,
+			RequestedDueAt: dueAt,
+			Status:         models.RequestPending,
+			Message:        message,
+			RequestedAt:    now,
+		})
+		r.History = append(r.History, models.RentalEvent{
+			Type:    models.RentalEventExtensionRequested,
+			At:      now,
+			ActorID: user.ID,
+			Note:    "Until " + dueAt.Format("2006-01-02"),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rental"})
+		return
+	}
+
+	text := fmt.Sprintf("%s would like to keep \"%s\" until %s instead of %s.",
+		user.Name, book.Title, dueAt.Format("Monday 2 January"), rental.Extensions[len(rental.Extensions)-1].PreviousDueAt.Format("Monday 2 January"))
+	if message != "" {
+		text += "\n\nTheir message:\n" + message
+	}
+	notifyUser(rental.OwnerID, "Extension requested for "+book.Title, text+"\n\nApprove or decline it from your dashboard.")
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Extension requested", "rental": rental})
+}
+
+// ApproveExtension moves the rental's due date to the one the renter asked for
+func ApproveExtension(c *gin.Context) {
+	decideExtension(c, models.RequestApproved)
+}
+
+// DeclineExtension keeps the rental's due date, optionally with a message
+func DeclineExtension(c *gin.Context) {
+	decideExtension(c, models.RequestDeclined)
+}
+
+// decideExtension approves or declines the rental's pending extension
+func decideExtension(c *gin.Context, status string) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	message, ok := bindRequestMessage(c)
+	if !ok {
+		return
+	}
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	rental, book, ok := loadActiveRental(c, user, policy.DecideExtension)
+	if !ok {
+		return
+	}
+	pending := rental.PendingExtension()
+	if pending < 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "There is no extension to decide on"})
+		return
+	}
+	// The limits may have been reached since the renter asked
+	if status == models.RequestApproved {
+		if denied := extensionDenied(rental); denied != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": denied})
+			return
+		}
+	}
+
+	now := time.Now()
+	eventType := models.RentalEventExtensionDeclined
+	if status == models.RequestApproved {
+		eventType = models.RentalEventExtensionApproved
+	}
+	// The extensions share their array with the stored rental, so the
+	// pending one is copied before it changes
+	previous, previousExt := rental, rental.Extensions[pending]
+	rental, err := models.UpdateRental(rental.ID, func(r *models.Rental) {
+		ext := &r.Extensions[pending]
+		ext.Status = status
+		ext.Response = message
+		ext.DecidedAt = &now
+		if status == models.RequestApproved {
+			dueAt := ext.RequestedDueAt
+			r.DueAt = &dueAt
+			// Start the reminders over for the new due date
+			r.Overdue = false
+			r.DueSoonRemindedAt = nil
+			r.OverdueRemindedAt = nil
+		}
+		r.History = append(r.History, models.RentalEvent{Type: eventType, At: now, ActorID: user.ID, Note: message})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rental"})
+		return
+	}
+
+	ext := rental.Extensions[pending]
+	if status == models.RequestApproved {
+		if err := chargeExtension(rental, ext, now); err != nil {
+			log.Printf("Failed to charge for extension %s of rental %s: %v", ext.ID, rental.ID, err)
+			undoExtensionDecision(previous, previousExt, pending, now)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to charge for the extension"})
+			return
+		}
+		notifyUser(rental.RenterID, "Extension approved for "+book.Title,
+			withMessage(fmt.Sprintf("You can keep \"%s\" until %s.", book.Title, ext.RequestedDueAt.Format("Monday 2 January")), message))
+	} else {
+		notifyUser(rental.RenterID, "Extension declined for "+book.Title,
+			withMessage(fmt.Sprintf("\"%s\" is still due back on %s.", book.Title, rental.DueAt.Format("Monday 2 January")), message))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Extension " + status, "rental": rental})
+}
+
+// undoExtensionDecision puts a rental's pending extension back as it was,
+// with the due date and reminders the rental had before the decision made at
+// now, for when the decision couldn't be carried out. Callers hold
+// rentalLock.
+func undoExtensionDecision(previous models.Rental, ext models.Extension, pending int, now time.Time) {
+	_, err := models.UpdateRental(previous.ID, func(r *models.Rental) {
+		r.Extensions[pending] = ext
+		r.DueAt = previous.DueAt
+		r.Overdue = previous.Overdue
+		r.DueSoonRemindedAt = previous.DueSoonRemindedAt
+		r.OverdueRemindedAt = previous.OverdueRemindedAt
+		history := r.History[:0]
+		for _, event := range r.History {
+			if event.Type != models.RentalEventExtensionApproved || !event.At.Equal(now) {
+				history = append(history, event)
+			}
+		}
+		r.History = history
+	})
+	if err != nil {
+		log.Printf("Failed to put extension %s of rental %s back to pending: %v", ext.ID, previous.ID, err)
+	}
+}
+
+// extensionDenied explains why the rental can't be extended, or returns "" if
+// it can
+func extensionDenied(rental models.Rental) string {
+	if limit := config.Get().MaxLoanExtensions; rental.ExtensionsGranted() >= limit {
+		return fmt.Sprintf("This rental has already been extended the maximum of %d times", limit)
+	}
+	if othersWaiting(rental.BookID) {
+		return "Other readers are waiting for this book, so it can't be extended"
+	}
+	return ""
+}
+
+// othersWaiting reports whether other seekers are on the book's waitlist,
+// still waiting for a copy. Seekers who requested or were offered another
+// copy aren't waiting on this one.
+func othersWaiting(bookID string) bool {
+	return models.HasWaitingSeekers(bookID)
+}
+
+// parseDueDate reads a new due date given either as a full RFC 3339 time or
+// as a date, which keeps the time of day of the current due date
+func parseDueDate(value string, current time.Time) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, current.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), current.Hour(), current.Minute(), current.Second(), 0, current.Location()), true
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/models"
+)
+
+func TestApproveExtensionUndoneWhenChargeFails(t *testing.T) {
+	useTempDataDir(t)
+	gin.SetMode(gin.TestMode)
+	owner := saveTestUser(t, models.User{Name: "Owner", Email: "extension-owner@example.com", EmailVerified: true})
+	renter := saveTestUser(t, models.User{Name: "Renter", Email: "extension-renter@example.com", EmailVerified: true})
+	_, rental := saveTestRental(t, owner, renter, models.BookCheckedOut)
+
+	// A per-day price without a currency can't be posted to the ledger
+	dueAt := *rental.DueAt
+	rental, err := models.UpdateRental(rental.ID, func(r *models.Rental) {
+		r.Pricing = &models.Pricing{Model: models.PricePerDay, Price: 100}
+		r.Extensions = append(r.Extensions, models.Extension{
+			ID:             "ext-1",
+			PreviousDueAt:  dueAt,
+			RequestedDueAt: dueAt.AddDate(0, 0, 7),
+			Status:         models.RequestPending,
+			RequestedAt:    time.Now(),
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.POST("/api/rentals/:id/extension/approve", func(c *gin.Context) { c.Set("user", owner) }, ApproveExtension)
+	if rec := postJSON(router, "/api/rentals/"+rental.ID+"/extension/approve", gin.H{}); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("approving answered %d: %s", rec.Code, rec.Body)
+	}
+
+	saved, _ := models.GetRentalByID(rental.ID)
+	if saved.PendingExtension() != 0 {
+		t.Errorf("extension is %q, want it pending again", saved.Extensions[0].Status)
+	}
+	if !saved.DueAt.Equal(dueAt) {
+		t.Errorf("due date moved to %v without the extension being paid for", saved.DueAt)
+	}
+	for _, event := range saved.History {
+		if event.Type == models.RentalEventExtensionApproved {
+			t.Error("the undone approval is still in the rental's history")
+		}
+	}
+}
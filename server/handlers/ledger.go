@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/models"
+)
+
+// balance is a user's position in one currency
+type balance struct {
+	Currency string `json:"currency"`
+	Balance  int64  `json:"balance"` // negative when the user owes money
+	Held     int64  `json:"held"`    // deposits held while their rentals are out
+}
+
+// GetMyBalance returns the current user's balance in each currency they have
+// rented or lent in
+func GetMyBalance(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	balances := make(map[string]*balance)
+	get := func(currency string) *balance {
+		if balances[currency] == nil {
+			balances[currency] = &balance{Currency: currency}
+		}
+		return balances[currency]
+	}
+	for currency, amount := range models.GetBalances(models.UserAccount(user.ID)) {
+		get(currency).Balance = amount
+	}
+	for currency, amount := range models.GetBalances(models.HeldAccount(user.ID)) {
+		get(currency).Held = amount
+	}
+
+	list := make([]balance, 0, len(balances))
+	for _, b := range balances {
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Currency < list[j].Currency })
+	c.JSON(http.StatusOK, gin.H{"balances": list})
+}
+
+// GetMyStatement lists the charges and credits on the current user's account
+// in one currency, newest first. The currency defaults to the site's.
+func GetMyStatement(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	currency := strings.ToUpper(c.DefaultQuery("currency", config.Get().DefaultCurrency))
+	lines := models.GetStatement(models.UserAccount(user.ID), currency)
+	c.JSON(http.StatusOK, gin.H{"currency": currency, "statement": lines})
+}
+
+// AccrueLateFees charges the renters of overdue books the book's late fee for
+// every full day past the due date. It is run by the reminder scheduler.
+// Books marked as returned stop accruing while the owner confirms.
+func AccrueLateFees(now time.Time) {
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	for _, rental := range models.GetActiveRentals() {
+		if rental.Pricing == nil || rental.Pricing.LateFee == 0 || rental.DueAt == nil {
+			continue
+		}
+		book, exists := models.GetBookByID(rental.BookID)
+		if !exists {
+			continue
+		}
+		if bookCopy := book.Copy(rental.CopyID); bookCopy == nil || bookCopy.Status != models.BookCheckedOut {
+			continue
+		}
+		from := *rental.DueAt
+		if rental.LateFeesThrough != nil && rental.LateFeesThrough.After(from) {
+			from = *rental.LateFeesThrough
+		}
+		days := int(now.Sub(from) / (24 * time.Hour))
+		if days <= 0 {
+			continue
+		}
+
+		// Move the rental on first, so a failure undercharges rather than
+		// charging the same days twice
+		through := from.Add(time.Duration(days) * 24 * time.Hour)
+		if _, err := models.UpdateRental(rental.ID, func(r *models.Rental) {
+			r.LateFeesThrough = &through
+		}); err != nil {
+			log.Printf("Failed to record late fees on rental %s: %v", rental.ID, err)
+			continue
+		}
+		memo := fmt.Sprintf("Late fee for \"%s\", %d %s overdue", rental.BookTitle, days, plural(days, "day", "days"))
+		err := postCharge(fmt.Sprintf("%s:%s:%d", models.LedgerLateFee, rental.ID, through.Unix()), models.LedgerLateFee,
+			rental, rental.Pricing.LateFee*int64(days), memo, now)
+		if err != nil {
+			log.Printf("Failed to charge late fees on rental %s: %v", rental.ID, err)
+		}
+	}
+}
+
+// chargeRentalStart charges the renter for the loan period and holds the
+// book's deposit. Callers hold rentalLock.
+func chargeRentalStart(rental models.Rental, now time.Time) error {
+	if rental.Pricing == nil || rental.DueAt == nil {
+		return nil
+	}
+	days := models.DaysBetween(rental.StartedAt, *rental.DueAt)
+	memo := fmt.Sprintf("Rental of \"%s\" for %d %s", rental.BookTitle, days, plural(days, "day", "days"))
+	if err := postCharge(models.LedgerRentalFee+":"+rental.ID, models.LedgerRentalFee,
+		rental, rental.Pricing.LoanCharge(days), memo, now); err != nil {
+		return err
+	}
+	return postTransfer(models.LedgerDepositHeld+":"+rental.ID, models.LedgerDepositHeld, rental,
+		models.UserAccount(rental.RenterID), models.HeldAccount(rental.RenterID), rental.Pricing.Deposit,
+		fmt.Sprintf("Deposit held for \"%s\"", rental.BookTitle), now)
+}
+
+// reverseRentalCharges cancels out whatever chargeRentalStart posted for a
+// rental whose approval was undone
+func reverseRentalCharges(rental models.Rental, now time.Time) {
+	for _, id := range []string{models.LedgerRentalFee + ":" + rental.ID, models.LedgerDepositHeld + ":" + rental.ID} {
+		t, posted := models.GetLedgerTransaction(id)
+		if !posted {
+			continue
+		}
+		entries := make([]models.LedgerEntry, 0, len(t.Entries))
+		for _, e := range t.Entries {
+			entries = append(entries, models.LedgerEntry{Account: e.Account, Amount: -e.Amount})
+		}
+		err := models.PostTransaction(models.LedgerTransaction{
+			ID:       models.LedgerReversal + ":" + id,
+			Type:     models.LedgerReversal,
+			Currency: t.Currency,
+			Entries:  entries,
+			RentalID: t.RentalID,
+			Memo:     "Reversed: " + t.Memo,
+			At:       now,
+		})
+		if err != nil && !errors.Is(err, models.ErrAlreadyPosted) {
+			log.Printf("Failed to reverse ledger transaction %s: %v", id, err)
+		}
+	}
+}
+
+// chargeExtension charges the renter of a book priced per day for the days
+// an approved extension adds. Callers hold rentalLock.
+func chargeExtension(rental models.Rental, ext models.Extension, now time.Time) error {
+	if rental.Pricing == nil || rental.Pricing.Model != models.PricePerDay {
+		return nil
+	}
+	days := models.DaysBetween(ext.PreviousDueAt, ext.RequestedDueAt)
+	memo := fmt.Sprintf("Extension of \"%s\" by %d %s", rental.BookTitle, days, plural(days, "day", "days"))
+	return postCharge(models.LedgerExtensionFee+":"+rental.ID+":"+ext.ID, models.LedgerExtensionFee,
+		rental, rental.Pricing.LoanCharge(days), memo, now)
+}
+
+// postCharge moves an amount from the renter to the owner of a rental
+func postCharge(id, kind string, rental models.Rental, amount int64, memo string, now time.Time) error {
+	return postTransfer(id, kind, rental, models.UserAccount(rental.RenterID), models.UserAccount(rental.OwnerID), amount, memo, now)
+}
+
+// postTransfer records an amount in the rental's currency moving from one
+// account to another. Nothing is posted for a zero amount, and a transaction
+// that was already posted is not an error.
+func postTransfer(id, kind string, rental models.Rental, from, to string, amount int64, memo string, now time.Time) error {
+	if amount == 0 {
+		return nil
+	}
+	err := models.PostTransaction(models.LedgerTransaction{
+		ID:       id,
+		Type:     kind,
+		Currency: rental.Pricing.Currency,
+		Entries:  []models.LedgerEntry{{Account: from, Amount: -amount}, {Account: to, Amount: amount}},
+		RentalID: rental.ID,
+		Memo:     memo,
+		At:       now,
+	})
+	if errors.Is(err, models.ErrAlreadyPosted) {
+		return nil
+	}
+	return err
+}
+
+// normalizePricing fills in the site's currency if the owner didn't name one
+// and upper-cases the code
+func normalizePricing(pricing *models.Pricing) {
+	if pricing == nil {
+		return
+	}
+	pricing.Currency = strings.ToUpper(strings.TrimSpace(pricing.Currency))
+	if pricing.Currency == "" {
+		pricing.Currency = config.Get().DefaultCurrency
+	}
+}
+
+// plural picks the singular or plural form of a word for a count
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
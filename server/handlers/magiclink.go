@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/mailer"
+	"nextchapter.com/m/middleware"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/validation"
+)
+
+const (
+	magicLinkTTL    = 15 * time.Minute
+	magicLinkCookie = "magic_login"
+)
+
+// magicLink is a sign in link that has been emailed and not yet used
+type magicLink struct {
+	userID      string
+	bindingHash [32]byte // hash of the cookie set on the browser that asked for the link
+	expiresAt   time.Time
+}
+
+// In-memory store of outstanding magic links
+var (
+	magicLinks    = make(map[string]magicLink) // maps token to link
+	magicLinkLock sync.Mutex
+
+	// Each email can be sent three links per 15 minutes
+	magicLinkLimiter = &middleware.RateLimiter{Limit: 3, Window: 15 * time.Minute}
+)
+
+// RequestMagicLink emails a single-use sign in link. The link only works in the
+// browser that asked for it. The response is the same whether or not the email
+// belongs to an account, so it cannot be used to discover users.
+func RequestMagicLink(c *gin.Context) {
+	var body struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	if wait, ok := magicLinkLimiter.Allow(strings.ToLower(strings.TrimSpace(body.Email)), time.Now()); !ok {
+		seconds := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":      fmt.Sprintf("Too many sign in links requested. Try again in %d seconds", seconds),
+			"retryAfter": seconds,
+		})
+		return
+	}
+
+	const message = "If an account exists for this email, a sign in link has been sent"
+
+	// The binding cookie is set whether or not the account exists, so the
+	// response doesn't give away which emails are registered
+	binding, err := generateSessionID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sign in link"})
+		return
+	}
+	middleware.SetCookie(c, magicLinkCookie, binding, magicLinkTTL, "/api/login/magic")
+
+	user, found := models.GetUserByEmail(body.Email)
+	if !found {
+		c.JSON(http.StatusOK, gin.H{"message": message})
+		return
+	}
+
+	token, err := generateSessionID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sign in link"})
+		return
+	}
+
+	magicLinkLock.Lock()
+	now := time.Now()
+	for t, link := range magicLinks {
+		if now.After(link.expiresAt) {
+			delete(magicLinks, t)
+		}
+	}
+	magicLinks[token] = magicLink{
+		userID:      user.ID,
+		bindingHash: sha256.Sum256([]byte(binding)),
+		expiresAt:   now.Add(magicLinkTTL),
+	}
+	magicLinkLock.Unlock()
+
+	link := fmt.Sprintf("%s/login/magic?token=%s", config.Get().AppURL, url.QueryEscape(token))
+	emailBody := fmt.Sprintf("Hi %s,\n\nOpen the link below in the same browser to sign in to NextChapter:\n\n%s\n\nThe link works once and expires in 15 minutes. If you didn't ask for it, you can ignore this email.", user.Name, link)
+	if err := mailer.Send(user.Email, "Your NextChapter sign in link", emailBody); err != nil {
+		log.Printf("Failed to send magic link to user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// VerifyMagicLink signs the user in with the token from a magic link. The
+// login then continues the same way as a password login, including 2FA.
+func VerifyMagicLink(c *gin.Context) {
+	var body struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	// Links are single use, so remove it whatever the outcome
+	magicLinkLock.Lock()
+	link, found := magicLinks[body.Token]
+	delete(magicLinks, body.Token)
+	magicLinkLock.Unlock()
+
+	if !found || time.Now().After(link.expiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "This sign in link is invalid or has expired"})
+		return
+	}
+
+	binding, hasCookie := middleware.ReadCookie(c, magicLinkCookie)
+	bindingHash := sha256.Sum256([]byte(binding))
+	if !hasCookie || subtle.ConstantTimeCompare(bindingHash[:], link.bindingHash[:]) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Open the sign in link in the browser you requested it from"})
+		return
+	}
+	middleware.ClearCookie(c, magicLinkCookie, "/api/login/magic")
+
+	user, found := models.GetUserByID(link.userID)
+	if !found {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "This sign in link is invalid or has expired"})
+		return
+	}
+
+	// Following the link proves the user controls the address
+	if !user.EmailVerified {
+		user.EmailVerified = true
+		if err := models.SaveUser(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			return
+		}
+	}
+
+	completeLogin(c, user)
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/middleware"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/oidc"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcLoginTTL    = 10 * time.Minute
+)
+
+// oidcLogin is an OIDC sign in waiting for the provider to redirect back
+type oidcLogin struct {
+	provider     string
+	nonce        string
+	codeVerifier string
+	role         string
+	expiresAt    time.Time
+}
+
+// In-memory store of OIDC logins in progress
+var (
+	oidcLogins    = make(map[string]oidcLogin) // maps state to login
+	oidcLoginLock sync.Mutex
+)
+
+// OIDCLogin starts signing in with an identity provider. The optional role
+// query parameter picks the role for a user signing in for the first time.
+func OIDCLogin(c *gin.Context) {
+	provider, exists := oidc.Get(c.Param("provider"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	state, err := oidc.NewState()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	nonce, err := oidc.NewState()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		log.Printf("OIDC provider %s unavailable: %v", provider.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	oidcLoginLock.Lock()
+	now := time.Now()
+	for s, login := range oidcLogins {
+		if now.After(login.expiresAt) {
+			delete(oidcLogins, s)
+		}
+	}
+	oidcLogins[state] = oidcLogin{
+		provider:     provider.Name,
+		nonce:        nonce,
+		codeVerifier: verifier,
+		role:         c.Query("role"),
+		expiresAt:    now.Add(oidcLoginTTL),
+	}
+	oidcLoginLock.Unlock()
+
+	// Bind the login to this browser so a callback URL cannot be replayed elsewhere
+	middleware.SetCookie(c, oidcStateCookie, state, oidcLoginTTL, "/api/auth/oidc")
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback finishes signing in once the provider redirects back with an
+// authorization code. The user is matched by their provider account, then by
+// verified email, or created. The login then continues the same way as a
+// password login: users with 2FA are sent to the client's two-factor page with
+// a pending login token, everyone else gets a session and the dashboard.
+func OIDCCallback(c *gin.Context) {
+	provider, exists := oidc.Get(c.Param("provider"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	if errCode := c.Query("error"); errCode != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Sign in was cancelled or refused: %s", errCode)})
+		return
+	}
+
+	state := c.Query("state")
+	cookieState, _ := middleware.ReadCookie(c, oidcStateCookie)
+	middleware.ClearCookie(c, oidcStateCookie, "/api/auth/oidc")
+
+	oidcLoginLock.Lock()
+	login, found := oidcLogins[state]
+	delete(oidcLogins, state)
+	oidcLoginLock.Unlock()
+
+	if !found || state == "" || cookieState != state || login.provider != provider.Name || time.Now().After(login.expiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(c.Request.Context(), c.Query("code"), login.codeVerifier)
+	if err != nil {
+		log.Printf("OIDC code exchange with %s failed: %v", provider.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not complete sign in with the identity provider"})
+		return
+	}
+	claims, err := provider.VerifyIDToken(c.Request.Context(), rawIDToken, login.nonce)
+	if err != nil {
+		log.Printf("OIDC ID token from %s rejected: %v", provider.Name, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid sign in from the identity provider"})
+		return
+	}
+
+	user, ok := findOrCreateOIDCUser(c, provider, claims, login.role)
+	if !ok {
+		return
+	}
+
+	token, ok := beginLogin(c, user)
+	if !ok {
+		return
+	}
+	if token != "" {
+		// The token goes in the fragment so it isn't sent to servers or leaked
+		// in the Referer header
+		c.Redirect(http.StatusFound, config.Get().AppURL+"/login/2fa#pendingToken="+url.QueryEscape(token))
+		return
+	}
+
+	if err := createSession(c, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	c.Redirect(http.StatusFound, config.Get().AppURL+"/dashboard")
+}
+
+// findOrCreateOIDCUser returns the user for the provider account, linking it to
+// an existing user with the same verified email or registering a new user.
+// Accounts are only linked if the local address is verified too, otherwise
+// whoever registered it first, without proving they own it, would share the
+// account with its real owner. It writes the error response and returns false
+// if the user can't be signed in.
+func findOrCreateOIDCUser(c *gin.Context, provider *oidc.Provider, claims *oidc.Claims, role string) (models.User, bool) {
+	identity := models.Identity{Provider: provider.Name, Subject: claims.Subject}
+	if user, found := models.GetUserByIdentity(identity.Provider, identity.Subject); found {
+		return user, true
+	}
+
+	if claims.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The identity provider did not share an email address"})
+		return models.User{}, false
+	}
+
+	if user, found := models.GetUserByEmail(claims.Email); found {
+		// Only an address the provider has verified may take over an account
+		if !claims.EmailVerified {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Your email is not verified with the identity provider, so it cannot be linked to your account"})
+			return models.User{}, false
+		}
+		if !user.EmailVerified {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An account with this email exists but its email is not verified. Sign in with your password and verify your email before linking it"})
+			return models.User{}, false
+		}
+		user.Identities = append(user.Identities, identity)
+		if err := models.SaveUser(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			return models.User{}, false
+		}
+		return user, true
+	}
+
+	if !isSelfAssignableRole(role) {
+		role = provider.DefaultRole
+	}
+	if !isSelfAssignableRole(role) {
+		role = models.RoleSeeker
+	}
+
+	id, err := generateID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate ID"})
+		return models.User{}, false
+	}
+	user := models.User{
+		ID:            id,
+		Name:          claims.Name,
+		Email:         claims.Email,
+		Roles:         []string{role},
+		EmailVerified: bool(claims.EmailVerified),
+		Identities:    []models.Identity{identity},
+	}
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save user"})
+		return models.User{}, false
+	}
+	if !user.EmailVerified {
+		if err := sendVerificationEmail(user, user.Email); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		}
+	}
+	return user, true
+}
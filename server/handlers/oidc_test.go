@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/oidc"
+	"nextchapter.com/m/oidc/oidctest"
+)
+
+// useTempDataDir runs the test in an empty directory so the stores write
+// their files there
+func useTempDataDir(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newOIDCTest starts a mock identity provider signing in identity and returns
+// it with a router serving the OIDC routes
+func newOIDCTest(t *testing.T, identity oidctest.Identity) (*oidctest.Server, *gin.Engine) {
+	t.Helper()
+	useTempDataDir(t)
+	gin.SetMode(gin.TestMode)
+
+	server := oidctest.NewServer("nextchapter", identity)
+	t.Cleanup(server.Close)
+	oidc.Register(oidc.NewProvider(oidc.ProviderConfig{
+		Name:        "mock",
+		Issuer:      server.Issuer(),
+		ClientID:    server.ClientID,
+		RedirectURL: "http://localhost:8080/api/auth/oidc/mock/callback",
+	}))
+
+	router := gin.New()
+	router.GET("/api/auth/oidc/:provider/login", OIDCLogin)
+	router.GET("/api/auth/oidc/:provider/callback", OIDCCallback)
+	return server, router
+}
+
+// oidcSignIn goes through the login redirect, the provider's authorization
+// endpoint and the callback, returning the callback's response
+func oidcSignIn(t *testing.T, router *gin.Engine) *httptest.ResponseRecorder {
+	t.Helper()
+	login := httptest.NewRecorder()
+	router.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/login", nil))
+	if login.Code != http.StatusFound {
+		t.Fatalf("login answered %d: %s", login.Code, login.Body)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(login.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callbackURL, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("provider answered %d with location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, callbackURL.RequestURI(), nil)
+	for _, cookie := range login.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	callback := httptest.NewRecorder()
+	router.ServeHTTP(callback, req)
+	return callback
+}
+
+func hasSessionCookie(rec *httptest.ResponseRecorder) bool {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == config.Get().SessionCookieName && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func saveTestUser(t *testing.T, user models.User) models.User {
+	t.Helper()
+	id, err := generateID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.ID = id
+	user.Roles = []string{models.RoleSeeker}
+	if err := models.SaveUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestOIDCCallbackRegistersNewUser(t *testing.T) {
+	_, router := newOIDCTest(t, oidctest.Identity{Subject: "new-1", Email: "new@example.com", EmailVerified: true, Name: "New Reader"})
+
+	rec := oidcSignIn(t, router)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != config.Get().AppURL+"/dashboard" {
+		t.Fatalf("callback answered %d with location %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	if !hasSessionCookie(rec) {
+		t.Error("no session cookie was set")
+	}
+	user, found := models.GetUserByIdentity("mock", "new-1")
+	if !found || user.Email != "new@example.com" || !user.EmailVerified {
+		t.Errorf("user not registered from the identity: %+v", user)
+	}
+}
+
+func TestOIDCCallbackRefusesToLinkUnverifiedAccount(t *testing.T) {
+	_, router := newOIDCTest(t, oidctest.Identity{Subject: "squat-1", Email: "squatted@example.com", EmailVerified: true})
+	squatter := saveTestUser(t, models.User{Name: "Squatter", Email: "squatted@example.com", Password: "chosen-by-squatter"})
+
+	rec := oidcSignIn(t, router)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback answered %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if hasSessionCookie(rec) {
+		t.Error("a session was started for the unverified account")
+	}
+	user, _ := models.GetUserByID(squatter.ID)
+	if len(user.Identities) != 0 || user.EmailVerified {
+		t.Errorf("unverified account was linked: %+v", user)
+	}
+}
+
+func TestOIDCCallbackAsksForSecondFactor(t *testing.T) {
+	_, router := newOIDCTest(t, oidctest.Identity{Subject: "totp-1", Email: "totp@example.com", EmailVerified: true})
+	owner := saveTestUser(t, models.User{Name: "Owner", Email: "totp@example.com", EmailVerified: true, TOTPEnabled: true, TOTPSecret: "JBSWY3DPEHPK3PXP"})
+
+	rec := oidcSignIn(t, router)
+	location := rec.Header().Get("Location")
+	prefix := config.Get().AppURL + "/login/2fa#pendingToken="
+	if rec.Code != http.StatusFound || !strings.HasPrefix(location, prefix) {
+		t.Fatalf("callback answered %d with location %q, want the two-factor page", rec.Code, location)
+	}
+	if hasSessionCookie(rec) {
+		t.Error("a session was started before the second factor was checked")
+	}
+	token, _ := url.QueryUnescape(strings.TrimPrefix(location, prefix))
+	if userID, ok := lookupPendingLogin(token); !ok || userID != owner.ID {
+		t.Errorf("pending login is for %q, want %q", userID, owner.ID)
+	}
+	if user, _ := models.GetUserByID(owner.ID); len(user.Identities) != 1 {
+		t.Errorf("verified account was not linked: %+v", user.Identities)
+	}
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/middleware"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/validation"
+	"nextchapter.com/m/webauthn"
+)
+
+const (
+	passkeyCeremonyTTL = 5 * time.Minute
+	maxPasskeyNameLen  = 64
+)
+
+// passkeyCeremony is a registration or login waiting for the authenticator's response
+type passkeyCeremony struct {
+	userID       string // the user adding a passkey, empty for logins
+	registration bool
+	expiresAt    time.Time
+}
+
+// In-memory store of passkey ceremonies in progress
+var (
+	passkeyCeremonies   = make(map[string]passkeyCeremony) // maps base64url challenge to ceremony
+	passkeyCeremonyLock sync.Mutex
+)
+
+// relyingParty returns the WebAuthn settings from the configuration
+func relyingParty() webauthn.RelyingParty {
+	cfg := config.Get()
+	return webauthn.RelyingParty{ID: cfg.WebAuthnRPID, Name: cfg.WebAuthnRPName, Origins: cfg.WebAuthnOrigins}
+}
+
+// startPasskeyCeremony remembers a challenge until the browser responds
+func startPasskeyCeremony(challenge []byte, ceremony passkeyCeremony) {
+	passkeyCeremonyLock.Lock()
+	defer passkeyCeremonyLock.Unlock()
+	now := time.Now()
+	for key, existing := range passkeyCeremonies {
+		if now.After(existing.expiresAt) {
+			delete(passkeyCeremonies, key)
+		}
+	}
+	ceremony.expiresAt = now.Add(passkeyCeremonyTTL)
+	passkeyCeremonies[webauthn.Encode(challenge)] = ceremony
+}
+
+// takePasskeyCeremony removes and returns the ceremony the client data's
+// challenge belongs to. Each challenge can only be answered once.
+func takePasskeyCeremony(clientDataJSON string, registration bool) ([]byte, passkeyCeremony, bool) {
+	challenge, err := webauthn.ChallengeFromClientData(clientDataJSON)
+	if err != nil {
+		return nil, passkeyCeremony{}, false
+	}
+	passkeyCeremonyLock.Lock()
+	defer passkeyCeremonyLock.Unlock()
+	key := webauthn.Encode(challenge)
+	ceremony, exists := passkeyCeremonies[key]
+	delete(passkeyCeremonies, key)
+	if !exists || ceremony.registration != registration || time.Now().After(ceremony.expiresAt) {
+		return nil, passkeyCeremony{}, false
+	}
+	return challenge, ceremony, true
+}
+
+// credentialIDs returns the raw IDs of the user's passkeys
+func credentialIDs(userID string) [][]byte {
+	ids := make([][]byte, 0)
+	for _, p := range models.GetPasskeysByUser(userID) {
+		if id, err := webauthn.Decode(p.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// decoyCredentialIDs stands in for the passkeys of an email that has none.
+// The ID is derived from the email with the server's key, so asking again
+// gives the same answer, as it would for a real passkey.
+func decoyCredentialIDs(email string) [][]byte {
+	return [][]byte{middleware.KeyedHash("passkey-decoy", strings.ToLower(strings.TrimSpace(email)))}
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create()
+// after checking the current password, so a stolen session can't be used to
+// add a passkey that outlives it
+func BeginPasskeyRegistration(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && c.Request.ContentLength > 0 {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+	if !confirmCurrentPassword(c, user, body.CurrentPassword) {
+		return
+	}
+
+	entity := webauthn.UserEntity{ID: webauthn.Encode([]byte(user.ID)), Name: user.Email, DisplayName: user.Name}
+	options, challenge, err := relyingParty().BeginRegistration(entity, credentialIDs(user.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+		return
+	}
+	startPasskeyCeremony(challenge, passkeyCeremony{userID: user.ID, registration: true})
+
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// FinishPasskeyRegistration verifies the new credential and stores it for the user
+func FinishPasskeyRegistration(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		Name       string                        `json:"name"`
+		Credential webauthn.RegistrationResponse `json:"credential" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	challenge, ceremony, found := takePasskeyCeremony(body.Credential.Response.ClientDataJSON, true)
+	if !found || ceremony.userID != user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey registration expired, please try again"})
+		return
+	}
+
+	cred, err := relyingParty().FinishRegistration(challenge, body.Credential)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey registration failed: " + err.Error()})
+		return
+	}
+
+	id := webauthn.Encode(cred.ID)
+	if _, taken := models.GetPasskey(id); taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "This passkey is already registered"})
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > maxPasskeyNameLen {
+		name = name[:maxPasskeyNameLen]
+	}
+
+	passkey := models.Passkey{
+		ID:        id,
+		UserID:    user.ID,
+		Name:      name,
+		PublicKey: cred.PublicKey,
+		Algorithm: cred.Algorithm,
+		SignCount: cred.SignCount,
+		AAGUID:    cred.AAGUID,
+		CreatedAt: time.Now(),
+	}
+	if err := models.SavePasskey(passkey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save passkey"})
+		return
+	}
+
+	notifyAccountChange(user.Email, "A passkey was added to your account",
+		fmt.Sprintf("Hi %s,\n\nA new passkey named \"%s\" can now be used to sign in to your NextChapter account.\n\nIf this wasn't you, remove the passkey, change your password and contact support right away.", user.Name, name))
+	c.JSON(http.StatusCreated, gin.H{"message": "Passkey registered", "passkey": passkey})
+}
+
+// passkeyNotice lists the user's passkeys for the end of an account change
+// notification. Passkeys keep working after the password or email changes, so
+// the user is asked to check for any they didn't add.
+func passkeyNotice(userID string) string {
+	passkeys := models.GetPasskeysByUser(userID)
+	if len(passkeys) == 0 {
+		return ""
+	}
+	lines := make([]string, 0, len(passkeys))
+	for _, p := range passkeys {
+		lines = append(lines, fmt.Sprintf("- %s, added %s", p.Name, p.CreatedAt.UTC().Format("2 January 2006")))
+	}
+	return "\n\nThese passkeys can also sign in to your account:\n" + strings.Join(lines, "\n") + "\n\nRemove any you don't recognize from your account settings."
+}
+
+// ListPasskeys returns the current user's passkeys
+func ListPasskeys(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	c.JSON(http.StatusOK, models.GetPasskeysByUser(user.ID))
+}
+
+// DeletePasskey removes one of the current user's passkeys
+func DeletePasskey(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	if err := models.DeletePasskey(user.ID, c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey removed"})
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get(). With
+// an email only that user's passkeys are offered; without one the browser lets
+// the user pick any passkey saved for the site. Emails without passkeys,
+// whether or not they have an account, are offered a decoy so the answer
+// doesn't reveal which emails are registered.
+func BeginPasskeyLogin(c *gin.Context) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && c.Request.ContentLength > 0 {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	allowed := [][]byte{}
+	if body.Email != "" {
+		if user, found := models.GetUserByEmail(body.Email); found {
+			allowed = credentialIDs(user.ID)
+		}
+		if len(allowed) == 0 {
+			allowed = decoyCredentialIDs(body.Email)
+		}
+	}
+
+	options, challenge, err := relyingParty().BeginLogin(allowed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	startPasskeyCeremony(challenge, passkeyCeremony{})
+
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// FinishPasskeyLogin verifies the assertion and signs the user in with the
+// same session as Login. A passkey that verified the user with a PIN or
+// biometric already counts as two factors, so 2FA is only asked for when the
+// authenticator only checked presence.
+func FinishPasskeyLogin(c *gin.Context) {
+	var body struct {
+		Credential webauthn.AssertionResponse `json:"credential" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	challenge, _, found := takePasskeyCeremony(body.Credential.Response.ClientDataJSON, false)
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login expired, please try again"})
+		return
+	}
+
+	rawID, err := webauthn.Decode(body.Credential.RawID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Malformed credential ID"})
+		return
+	}
+	passkey, found := models.GetPasskey(webauthn.Encode(rawID))
+	if !found {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "This passkey is not registered"})
+		return
+	}
+	user, found := models.GetUserByID(passkey.UserID)
+	if !found {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "This passkey is not registered"})
+		return
+	}
+
+	if loginLockedOut(c, user.Email) {
+		return
+	}
+
+	// Discoverable credentials report the account they were created for
+	if handle := body.Credential.Response.UserHandle; handle != "" && handle != webauthn.Encode([]byte(user.ID)) {
+		recordLoginFailure(c, user.Email, user.ID, "passkey user mismatch")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid passkey"})
+		return
+	}
+
+	assertion, err := relyingParty().FinishLogin(challenge, body.Credential, webauthn.Credential{
+		ID:        rawID,
+		PublicKey: passkey.PublicKey,
+		Algorithm: passkey.Algorithm,
+		SignCount: passkey.SignCount,
+	})
+	if err != nil {
+		log.Printf("Passkey login for user %s rejected: %v", user.ID, err)
+		recordLoginFailure(c, user.Email, user.ID, "invalid passkey")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid passkey"})
+		return
+	}
+
+	if err := models.UpdatePasskeySignCount(passkey.ID, passkey.SignCount, assertion.SignCount, time.Now()); err != nil {
+		recordLoginFailure(c, user.Email, user.ID, "passkey replay")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid passkey"})
+		return
+	}
+
+	if assertion.UserVerified {
+		startSession(c, user)
+		return
+	}
+	completeLogin(c, user)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/mailer"
+	"nextchapter.com/m/middleware"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/webauthn"
+	"nextchapter.com/m/webauthn/webauthntest"
+)
+
+// recordingMailer keeps the emails sent during a test
+type recordingMailer struct {
+	mu       sync.Mutex
+	subjects []string
+}
+
+func (m *recordingMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subjects = append(m.subjects, subject)
+	return nil
+}
+
+func (m *recordingMailer) sent(subject string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.subjects {
+		if s == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// newPasskeyTest returns a router serving the passkey routes, with the
+// authenticated ones signed in to a new session for user
+func newPasskeyTest(t *testing.T, user models.User) (*gin.Engine, *recordingMailer) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	mail := &recordingMailer{}
+	mailer.SetMailer(mail)
+	t.Cleanup(func() { mailer.SetMailer(mailer.LogMailer{}) })
+
+	sessionID, err := generateSessionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	middleware.SetSession(sessionID, user.ID)
+	t.Cleanup(func() { middleware.RemoveSession(sessionID) })
+
+	router := gin.New()
+	router.POST("/api/login/passkey/begin", BeginPasskeyLogin)
+	router.POST("/api/login/passkey/finish", FinishPasskeyLogin)
+	authenticated := router.Group("/api", func(c *gin.Context) {
+		c.Set("user", user)
+		c.Set("sessionID", sessionID)
+	})
+	authenticated.POST("/me/passkeys/register/begin", BeginPasskeyRegistration)
+	authenticated.POST("/me/passkeys/register/finish", FinishPasskeyRegistration)
+	return router, mail
+}
+
+func postJSON(router *gin.Engine, path string, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// registerPasskey runs the registration ceremony with the authenticator,
+// confirming with the given password
+func registerPasskey(t *testing.T, router *gin.Engine, authenticator *webauthntest.Authenticator, currentPassword string) *httptest.ResponseRecorder {
+	t.Helper()
+	begin := postJSON(router, "/api/me/passkeys/register/begin", gin.H{"currentPassword": currentPassword})
+	if begin.Code != http.StatusOK {
+		return begin
+	}
+	var options struct {
+		PublicKey webauthn.CreationOptions `json:"publicKey"`
+	}
+	if err := json.Unmarshal(begin.Body.Bytes(), &options); err != nil {
+		t.Fatal(err)
+	}
+	credential, err := authenticator.Create(options.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return postJSON(router, "/api/me/passkeys/register/finish", gin.H{"name": "Laptop", "credential": credential})
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	useTempDataDir(t)
+	user := saveTestUser(t, models.User{Name: "Reader", Email: "passkey@example.com", Password: "correct horse battery", EmailVerified: true})
+	router, mail := newPasskeyTest(t, user)
+	authenticator := webauthntest.New(relyingParty().Origins[0])
+
+	if rec := registerPasskey(t, router, authenticator, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("registration without the password answered %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := registerPasskey(t, router, authenticator, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("registration with a wrong password answered %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := registerPasskey(t, router, authenticator, user.Password); rec.Code != http.StatusCreated {
+		t.Fatalf("registration answered %d: %s", rec.Code, rec.Body)
+	}
+	if !mail.sent("A passkey was added to your account") {
+		t.Error("no notification was sent for the new passkey")
+	}
+	if passkeys := models.GetPasskeysByUser(user.ID); len(passkeys) != 1 || !strings.Contains(passkeyNotice(user.ID), "Laptop") {
+		t.Fatalf("passkey not saved or not listed in notifications: %+v", passkeys)
+	}
+
+	begin := postJSON(router, "/api/login/passkey/begin", gin.H{"email": user.Email})
+	var options struct {
+		PublicKey webauthn.RequestOptions `json:"publicKey"`
+	}
+	if err := json.Unmarshal(begin.Body.Bytes(), &options); err != nil {
+		t.Fatal(err)
+	}
+	assertion, err := authenticator.Get(options.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := postJSON(router, "/api/login/passkey/finish", gin.H{"credential": assertion}); rec.Code != http.StatusOK || !hasSessionCookie(rec) {
+		t.Fatalf("passkey login answered %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPasskeyRegistrationWithoutPasswordNeedsRecentLogin(t *testing.T) {
+	useTempDataDir(t)
+	user := saveTestUser(t, models.User{Name: "Reader", Email: "nopassword@example.com", EmailVerified: true})
+	router, _ := newPasskeyTest(t, user)
+	authenticator := webauthntest.New(relyingParty().Origins[0])
+
+	// The session was just created, which counts as a recent login
+	if rec := registerPasskey(t, router, authenticator, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("registration answered %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// beginPasskeyLogin returns the credentials the login options offer for email
+func beginPasskeyLogin(t *testing.T, router *gin.Engine, email string) []webauthn.CredentialDescriptor {
+	t.Helper()
+	rec := postJSON(router, "/api/login/passkey/begin", gin.H{"email": email})
+	var options struct {
+		PublicKey webauthn.RequestOptions `json:"publicKey"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &options); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("login options answered %d: %s", rec.Code, rec.Body)
+	}
+	return options.PublicKey.AllowCredentials
+}
+
+func TestPasskeyLoginOptionsDontRevealAccounts(t *testing.T) {
+	useTempDataDir(t)
+	user := saveTestUser(t, models.User{Name: "Reader", Email: "nopasskey@example.com", EmailVerified: true})
+	router, _ := newPasskeyTest(t, user)
+
+	unknown := beginPasskeyLogin(t, router, "nobody@example.com")
+	if len(unknown) != 1 {
+		t.Fatalf("unknown email was offered %d credentials, want a decoy", len(unknown))
+	}
+	if again := beginPasskeyLogin(t, router, "nobody@example.com"); again[0].ID != unknown[0].ID {
+		t.Error("the decoy changed between requests")
+	}
+	if registered := beginPasskeyLogin(t, router, user.Email); len(registered) != 1 || registered[0].ID == unknown[0].ID {
+		t.Errorf("registered email without passkeys was offered %+v", registered)
+	}
+}
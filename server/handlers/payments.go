@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/payments"
+	"nextchapter.com/m/validation"
+)
+
+// PaymentWebhook receives notifications from the payment provider. Webhooks
+// without a valid signature are refused. A deposit hold the provider lets go
+// while the book is still out, such as one that expired, is recorded in the
+// rental's history.
+func PaymentWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	event, err := payments.Current().VerifyWebhook(payload, c.GetHeader(payments.SignatureHeader))
+	if err != nil {
+		log.Printf("Rejected payment webhook: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook"})
+		return
+	}
+
+	if event.Type == payments.EventReleased {
+		rentalLock.Lock()
+		defer rentalLock.Unlock()
+		if rental, found := models.GetRentalByDepositPayment(event.PaymentID); found && rental.Active() {
+			_, err := models.AddRentalEvent(rental.ID, models.RentalEvent{
+				Type: models.RentalEventDepositReleased,
+				At:   time.Now(),
+				Note: "The payment provider released the deposit hold",
+			})
+			if err != nil {
+				log.Printf("Failed to record the released deposit of rental %s: %v", rental.ID, err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+// errNoPaymentMethod is returned when a deposit is needed from a user who
+// hasn't saved a payment method
+var errNoPaymentMethod = errors.New("no payment method saved")
+
+// SetPaymentMethod saves the card the client collected with the payment
+// provider's SDK as the current user's payment method for deposits
+func SetPaymentMethod(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		PaymentMethod string `json:"paymentMethod" binding:"required,max=255"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	customerID, err := payments.Current().CreateCustomer(c.Request.Context(), payments.CustomerRequest{
+		Email:         user.Email,
+		Name:          user.Name,
+		PaymentMethod: body.PaymentMethod,
+	})
+	if errors.Is(err, payments.ErrDeclined) {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "The card was declined"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save the payment method of user %s: %v", user.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "The payment method couldn't be saved. Please try again later"})
+		return
+	}
+
+	user.PaymentCustomerID = customerID
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment method saved", "user": user.WithoutSecrets()})
+}
+
+// requirePaymentMethod writes a 402 response if the book takes a deposit and
+// the user has no payment method to hold it on
+func requirePaymentMethod(c *gin.Context, user models.User, book models.Book) bool {
+	if book.Pricing == nil || book.Pricing.Deposit == 0 || user.PaymentCustomerID != "" {
+		return true
+	}
+	c.JSON(http.StatusPaymentRequired, gin.H{"error": "Add a payment method before requesting a book that takes a deposit"})
+	return false
+}
+
+// authorizeDeposit holds the book's deposit on the seeker's card before a
+// request is approved. It returns an empty payment if the book takes no
+// deposit, and errNoPaymentMethod if the seeker has no card saved.
+// Authorizing the same request twice holds the deposit once.
+func authorizeDeposit(ctx context.Context, book models.Book, request models.RentalRequest) (payments.Payment, error) {
+	if book.Pricing == nil || book.Pricing.Deposit == 0 {
+		return payments.Payment{}, nil
+	}
+	seeker, found := models.GetUserByID(request.SeekerID)
+	if !found || seeker.PaymentCustomerID == "" {
+		return payments.Payment{}, errNoPaymentMethod
+	}
+	return payments.Current().Authorize(ctx, payments.AuthorizeRequest{
+		Amount:      book.Pricing.Deposit,
+		Currency:    book.Pricing.Currency,
+		Customer:    seeker.PaymentCustomerID,
+		Reference:   "deposit:" + request.ID,
+		Description: fmt.Sprintf("Deposit for \"%s\"", book.Title),
+	})
+}
+
+// paymentTimeout bounds the provider calls made to release deposits, which
+// carry on after the request that caused them has been answered
+const paymentTimeout = 30 * time.Second
+
+// releaseHold lets go of a deposit that was authorized for a request that
+// ended up not being approved. Failures are logged and the hold is left to
+// expire with the provider. Callers must not hold rentalLock.
+func releaseHold(deposit payments.Payment, reason string) {
+	if deposit.ID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), paymentTimeout)
+	defer cancel()
+	if _, err := payments.Current().Refund(ctx, deposit.ID, deposit.Amount); err != nil {
+		log.Printf("Failed to release deposit %s after %s: %v", deposit.ID, reason, err)
+	}
+}
+
+// releaseDeposit gives the renter back the deposit of a rental that has
+// ended: the payment provider lets the hold on their card go and, once it
+// has, the ledger moves the deposit out of their held account. If the
+// provider fails the deposit stays held in the ledger and
+// RetryDepositReleases tries again. The provider may be slow, so callers
+// must not hold rentalLock; the ledger only posts the release once.
+func releaseDeposit(rental models.Rental, now time.Time) error {
+	if rental.Pricing == nil {
+		return nil
+	}
+	if rental.DepositPaymentID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), paymentTimeout)
+		defer cancel()
+		if _, err := payments.Current().Refund(ctx, rental.DepositPaymentID, rental.Pricing.Deposit); err != nil {
+			return fmt.Errorf("releasing payment %s: %w", rental.DepositPaymentID, err)
+		}
+	}
+	return postTransfer(models.LedgerDepositReleased+":"+rental.ID, models.LedgerDepositReleased, rental,
+		models.HeldAccount(rental.RenterID), models.UserAccount(rental.RenterID), rental.Pricing.Deposit,
+		fmt.Sprintf("Deposit released for \"%s\"", rental.BookTitle), now)
+}
+
+// RetryDepositReleases releases the deposits still held for rentals that have
+// ended, whose release failed because the payment provider couldn't be
+// reached or refused. It is run by the reminder scheduler. Ended rentals
+// don't change, so it runs without rentalLock and doesn't hold up other
+// rental changes while it waits for the provider.
+func RetryDepositReleases(now time.Time) {
+	for _, rental := range models.GetEndedRentals() {
+		if rental.Pricing == nil || rental.Pricing.Deposit == 0 {
+			continue
+		}
+		if _, held := models.GetLedgerTransaction(models.LedgerDepositHeld + ":" + rental.ID); !held {
+			continue
+		}
+		if _, released := models.GetLedgerTransaction(models.LedgerDepositReleased + ":" + rental.ID); released {
+			continue
+		}
+		if err := releaseDeposit(rental, now); err != nil {
+			log.Printf("Failed again to release the deposit of rental %s: %v", rental.ID, err)
+		}
+	}
+}
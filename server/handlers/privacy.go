@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/policy"
+)
+
+// viewer returns the signed in user making the request, or an empty user for
+// anonymous requests
+func viewer(c *gin.Context) models.User {
+	if userObj, exists := c.Get("user"); exists {
+		return userObj.(models.User)
+	}
+	return models.User{}
+}
+
+// redactBook hides the listing's contact info unless the viewer owns the book
+// or the owner has accepted the viewer's rental. Who has each copy out is
+// shown to the owner, and to a renter for their own copy.
+func redactBook(viewer models.User, book models.Book) models.Book {
+	if !policy.Can(viewer, policy.ViewBookContact, book) {
+		book.ContactInfo = ""
+	}
+	if viewer.ID == "" || viewer.ID != book.OwnerID {
+		// The copies share their array with the stored book
+		copies := make([]models.BookCopy, len(book.Copies))
+		for i, bookCopy := range book.Copies {
+			if bookCopy.RenterID != viewer.ID {
+				bookCopy.RenterID = ""
+			}
+			copies[i] = bookCopy
+		}
+		book.Copies = copies
+	}
+	return book
+}
+
+// redactBooks applies redactBook to every book in a list
+func redactBooks(viewer models.User, books []models.Book) []models.Book {
+	redacted := make([]models.Book, 0, len(books))
+	for _, book := range books {
+		redacted = append(redacted, redactBook(viewer, book))
+	}
+	return redacted
+}
+
+// canSeeContactField reports whether the viewer may see one of the subject's
+// contact details with the given visibility setting
+func canSeeContactField(viewer, subject models.User, visibility string) bool {
+	if viewer.ID != "" && viewer.ID == subject.ID {
+		return true
+	}
+	switch visibility {
+	case models.VisibilityPublic:
+		return true
+	case models.VisibilityPrivate:
+		return false
+	}
+	return policy.Can(viewer, policy.ViewUserContact, subject)
+}
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/notify"
+	"nextchapter.com/m/payments"
+	"nextchapter.com/m/policy"
+	"nextchapter.com/m/validation"
+)
+
+// rentalLock serializes changes to a book's requests and status, so two
+// approvals or an approval and a status change can't interleave
+var rentalLock sync.Mutex
+
+// requestMessage is the optional note, up to 500 characters, sent with a
+// request or decision
+type requestMessage struct {
+	Message string `json:"message" binding:"max=500"`
+}
+
+// bindRequestMessage reads the optional message body. An empty body is fine.
+func bindRequestMessage(c *gin.Context) (string, bool) {
+	var body requestMessage
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+			return "", false
+		}
+	}
+	return strings.TrimSpace(body.Message), true
+}
+
+// RequestBook asks the owner to rent a copy of the book. An available copy is
+// allocated if there is one; otherwise the request joins others for a copy
+// that is already requested. The copy is marked requested while the owner
+// decides.
+func RequestBook(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	message, ok := bindRequestMessage(c)
+	if !ok {
+		return
+	}
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	id := c.Param("id")
+	book, exists := models.GetBookByID(id)
+	if !exists || ownerSuspended(book) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+
+	if err := policy.Check(user, policy.RequestBook, book); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	if waitlistOpen(book) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This book is on loan or promised to readers on its waitlist. Join the waitlist to be offered it"})
+		return
+	}
+	bookCopy, available := book.RequestableCopy()
+	if !available {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Book is not available for rent"})
+		return
+	}
+	if models.HasPendingRentalRequest(book.ID, user.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already requested this book"})
+		return
+	}
+	if !requirePaymentMethod(c, user, book) {
+		return
+	}
+
+	request, err := createRentalRequest(user, &book, bookCopy.ID, message, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save request"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Request sent to the owner", "request": request})
+}
+
+// createRentalRequest records the seeker's request for a copy of the book,
+// marks the copy requested and tells the owner. Callers hold rentalLock.
+func createRentalRequest(user models.User, book *models.Book, copyID, message string, now time.Time) (models.RentalRequest, error) {
+	requestID, err := generateID()
+	if err != nil {
+		return models.RentalRequest{}, err
+	}
+	request := models.RentalRequest{
+		ID:        requestID,
+		BookID:    book.ID,
+		CopyID:    copyID,
+		OwnerID:   book.OwnerID,
+		SeekerID:  user.ID,
+		Status:    models.RequestPending,
+		Message:   message,
+		CreatedAt: now,
+	}
+	if err := models.SaveRentalRequest(request); err != nil {
+		return models.RentalRequest{}, err
+	}
+	if bookCopy := book.Copy(copyID); bookCopy != nil && bookCopy.Status != models.BookRequested {
+		if err := transitionCopy(book, copyID, models.BookRequested); err != nil {
+			log.Printf("Failed to mark copy %s of book %s as requested: %v", copyID, book.ID, err)
+		}
+	}
+
+	body := fmt.Sprintf("%s would like to rent \"%s\".", user.Name, book.Title)
+	if message != "" {
+		body += "\n\nTheir message:\n" + message
+	}
+	notifyUser(book.OwnerID, "New request for "+book.Title, body+"\n\nApprove or decline it from your dashboard.")
+	return request, nil
+}
+
+// ListRentalRequests returns the requests the current user has sent as a
+// seeker and received as an owner
+func ListRentalRequests(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	c.JSON(http.StatusOK, gin.H{
+		"sent":     models.GetRentalRequestsBySeeker(user.ID),
+		"received": models.GetRentalRequestsByOwner(user.ID),
+	})
+}
+
+// ListBookRequests returns every request for one of the owner's books
+func ListBookRequests(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	book, exists := models.GetBookByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+	if err := policy.Check(user, policy.ListBookRequests, book); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"requests": models.GetRentalRequestsByBook(book.ID)})
+}
+
+// ApproveRentalRequest lends the requested copy to the seeker. Every other
+// pending request for the copy is declined.
+func ApproveRentalRequest(c *gin.Context) {
+	decideRentalRequest(c, models.RequestApproved)
+}
+
+// DeclineRentalRequest turns a request down, optionally with a message
+func DeclineRentalRequest(c *gin.Context) {
+	decideRentalRequest(c, models.RequestDeclined)
+}
+
+// CancelRentalRequest withdraws one of the seeker's pending requests
+func CancelRentalRequest(c *gin.Context) {
+	decideRentalRequest(c, models.RequestCancelled)
+}
+
+// decideRentalRequest moves a pending request to the given status after
+// checking the current user may do so
+func decideRentalRequest(c *gin.Context, status string) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	message, ok := bindRequestMessage(c)
+	if !ok {
+		return
+	}
+
+	rentalLock.Lock()
+	request, book, ok := pendingDecision(c, user, status)
+	rentalLock.Unlock()
+	if !ok {
+		return
+	}
+
+	// The book is only lent once the seeker's deposit is held. The payment
+	// provider is called without holding rentalLock, so everything is checked
+	// again afterwards in case the request was decided in the meantime.
+	var deposit payments.Payment
+	if status == models.RequestApproved {
+		var err error
+		deposit, err = authorizeDeposit(c.Request.Context(), book, request)
+		if errors.Is(err, errNoPaymentMethod) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "The seeker hasn't saved a payment method for the deposit, so the book can't be lent to them"})
+			return
+		}
+		if errors.Is(err, payments.ErrDeclined) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "The seeker's deposit was declined, so the book can't be lent to them"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to authorize the deposit for request %s: %v", request.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "The seeker's deposit couldn't be authorized. Please try again later"})
+			return
+		}
+	}
+
+	rentalLock.Lock()
+	approved := finishDecision(c, user, status, deposit, message)
+	rentalLock.Unlock()
+
+	// A deposit held for a request that didn't end up approved is let go
+	// once rentalLock is released, as the provider may be slow
+	if !approved {
+		releaseHold(deposit, "the approval didn't go through")
+	}
+}
+
+// finishDecision checks again that the user may decide the request and
+// records the decision, reporting whether the request was approved. Callers
+// hold rentalLock.
+func finishDecision(c *gin.Context, user models.User, status string, deposit payments.Payment, message string) bool {
+	request, book, ok := pendingDecision(c, user, status)
+	if !ok {
+		return false
+	}
+	if status == models.RequestApproved {
+		return approveRentalRequest(c, book, request, deposit, message)
+	}
+
+	request, _, err := models.DecideRentalRequest(request.ID, status, message, time.Now())
+	if errors.Is(err, models.ErrRequestNotPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This request has already been decided"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update request"})
+		return false
+	}
+
+	// The copy is released once nobody is waiting on it
+	if bookCopy := book.Copy(request.CopyID); bookCopy != nil && bookCopy.Status == models.BookRequested &&
+		!models.HasPendingRequestsForCopy(book.ID, request.CopyID) {
+		if err := releaseCopy(&book, request.CopyID, time.Now()); err != nil {
+			log.Printf("Failed to release copy %s of book %s after request %s was %s: %v", request.CopyID, book.ID, request.ID, status, err)
+		}
+	}
+
+	if status == models.RequestDeclined {
+		notifyUser(request.SeekerID, "Your request for "+book.Title+" was declined",
+			withMessage(fmt.Sprintf("Sorry, the owner can't lend you \"%s\" this time.", book.Title), message))
+	} else {
+		notifyUser(request.OwnerID, "A request for "+book.Title+" was withdrawn",
+			fmt.Sprintf("%s no longer needs \"%s\".", user.Name, book.Title))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Request " + status, "request": request})
+	return false
+}
+
+// pendingDecision loads a request the user is about to decide and its book,
+// writing an error response if the user may not decide it or it can no
+// longer be decided. Callers hold rentalLock.
+func pendingDecision(c *gin.Context, user models.User, status string) (models.RentalRequest, models.Book, bool) {
+	request, exists := models.GetRentalRequestByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Request not found"})
+		return models.RentalRequest{}, models.Book{}, false
+	}
+
+	action := policy.DecideRequest
+	if status == models.RequestCancelled {
+		action = policy.CancelRequest
+	}
+	if err := policy.Check(user, action, request); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return models.RentalRequest{}, models.Book{}, false
+	}
+
+	if request.Status != models.RequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "This request has already been " + request.Status})
+		return models.RentalRequest{}, models.Book{}, false
+	}
+
+	book, exists := models.GetBookByID(request.BookID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return models.RentalRequest{}, models.Book{}, false
+	}
+	if bookCopy := book.Copy(request.CopyID); status == models.RequestApproved && (bookCopy == nil || bookCopy.Status != models.BookRequested) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Book is not available for rent"})
+		return models.RentalRequest{}, models.Book{}, false
+	}
+	return request, book, true
+}
+
+// approveRentalRequest lends the requested copy to the seeker: the request is
+// approved, declining the others for the copy, a rental is started, the copy
+// is checked out and the rental is charged. If any step fails the ones before
+// it are undone, newest first, so the request is left pending as it was, and
+// false is returned for the caller to release the deposit hold. Callers hold
+// rentalLock.
+func approveRentalRequest(c *gin.Context, book models.Book, request models.RentalRequest, deposit payments.Payment, message string) bool {
+	now := time.Now()
+	var undo []func()
+	fail := func(status int, errorMessage string) bool {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		c.JSON(status, gin.H{"error": errorMessage})
+		return false
+	}
+
+	request, declined, err := models.DecideRentalRequest(request.ID, models.RequestApproved, message, now)
+	if errors.Is(err, models.ErrRequestNotPending) {
+		return fail(http.StatusConflict, "This request has already been decided")
+	}
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Failed to update request")
+	}
+	undo = append(undo, func() {
+		ids := []string{request.ID}
+		for _, other := range declined {
+			ids = append(ids, other.ID)
+		}
+		if err := models.ReopenRentalRequests(ids); err != nil {
+			log.Printf("Failed to reopen requests %v after the approval of request %s failed: %v", ids, request.ID, err)
+		}
+	})
+
+	rental, err := startRental(book, request, deposit.ID, now)
+	if err != nil {
+		log.Printf("Failed to record rental of book %s after approving request %s: %v", book.ID, request.ID, err)
+		return fail(http.StatusInternalServerError, "Failed to record rental")
+	}
+	undo = append(undo, func() {
+		if err := models.DeleteRental(rental.ID); err != nil {
+			log.Printf("Failed to delete rental %s after its approval failed: %v", rental.ID, err)
+		}
+	})
+
+	book.Copy(request.CopyID).RenterID = request.SeekerID
+	if err := transitionCopy(&book, request.CopyID, models.BookCheckedOut); err != nil {
+		log.Printf("Failed to check out book %s after approving request %s: %v", book.ID, request.ID, err)
+		return fail(http.StatusInternalServerError, "Failed to update book")
+	}
+	undo = append(undo, func() {
+		book.Copy(request.CopyID).RenterID = ""
+		book.SetCopyStatus(request.CopyID, models.BookRequested)
+		if err := models.UpdateBook(book); err != nil {
+			log.Printf("Failed to put copy %s of book %s back to requested after its approval failed: %v", request.CopyID, book.ID, err)
+		}
+	})
+
+	if err := chargeRentalStart(rental, now); err != nil {
+		log.Printf("Failed to charge for rental %s: %v", rental.ID, err)
+		undo = append(undo, func() { reverseRentalCharges(rental, time.Now()) })
+		return fail(http.StatusInternalServerError, "Failed to charge for the rental")
+	}
+
+	notifyUser(request.SeekerID, "Your request for "+book.Title+" was approved",
+		withMessage(fmt.Sprintf("Good news! The owner has agreed to lend you \"%s\" until %s. Their contact details are now on the book's page.",
+			book.Title, rental.DueAt.Format("Monday 2 January")), message))
+	for _, other := range declined {
+		notifyUser(other.SeekerID, "Your request for "+book.Title+" was declined",
+			fmt.Sprintf("Sorry, \"%s\" has been lent to someone else.", book.Title))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Request " + models.RequestApproved, "request": request})
+	return true
+}
+
+// declineBookRequests declines the pending requests for a book that has been
+// removed and tells their seekers. Callers hold rentalLock.
+func declineBookRequests(book models.Book, now time.Time) {
+	for _, request := range models.GetRentalRequestsByBook(book.ID) {
+		if request.Status != models.RequestPending {
+			continue
+		}
+		if _, _, err := models.DecideRentalRequest(request.ID, models.RequestDeclined, "The owner has removed the book", now); err != nil {
+			if !errors.Is(err, models.ErrRequestNotPending) {
+				log.Printf("Failed to decline request %s: %v", request.ID, err)
+			}
+			continue
+		}
+		notifyUser(request.SeekerID, "Your request for "+book.Title+" was declined",
+			fmt.Sprintf("Sorry, the owner has removed \"%s\", so your request has been declined.", book.Title))
+	}
+}
+
+// withMessage appends the owner's note to a notification
+func withMessage(body, message string) string {
+	if message == "" {
+		return body
+	}
+	return body + "\n\nMessage from the owner:\n" + message
+}
+
+// notifyUser tells a user about activity on their requests and rentals
+func notifyUser(userID, subject, body string) {
+	if err := notify.User(userID, subject, body); err != nil {
+		log.Printf("Failed to send notification to user %s: %v", userID, err)
+	}
+}
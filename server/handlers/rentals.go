@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/policy"
+)
+
+// ListRentals returns the current user's rentals, past and present: the books
+// they have borrowed and the books they have lent
+func ListRentals(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	c.JSON(http.StatusOK, gin.H{
+		"borrowed": models.GetRentalsByRenter(user.ID),
+		"lent":     models.GetRentalsByOwner(user.ID),
+	})
+}
+
+// ListOverdueRentals returns the current user's overdue rentals: books they
+// should have returned and books they are waiting to get back
+func ListOverdueRentals(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	c.JSON(http.StatusOK, gin.H{
+		"borrowed": overdue(models.GetRentalsByRenter(user.ID)),
+		"lent":     overdue(models.GetRentalsByOwner(user.ID)),
+	})
+}
+
+// overdue keeps the active rentals the reminder scheduler has marked overdue
+func overdue(rentals []models.Rental) []models.Rental {
+	list := make([]models.Rental, 0)
+	for _, rental := range rentals {
+		if rental.Active() && rental.Overdue {
+			list = append(list, rental)
+		}
+	}
+	return list
+}
+
+// GetRental returns one rental with its history
+func GetRental(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	rental, exists := models.GetRentalByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rental not found"})
+		return
+	}
+	if err := policy.Check(user, policy.ViewRental, rental); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rental": rental})
+}
+
+// MarkReturned lets the renter say they have given the book back. The rental
+// stays open until the owner confirms.
+func MarkReturned(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	message, ok := bindRequestMessage(c)
+	if !ok {
+		return
+	}
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	rental, book, ok := loadActiveRental(c, user, policy.ReturnRental)
+	if !ok {
+		return
+	}
+	if book.Copy(rental.CopyID).Status != models.BookCheckedOut {
+		c.JSON(http.StatusConflict, gin.H{"error": "This book has already been marked as returned"})
+		return
+	}
+
+	now := time.Now()
+	if err := transitionCopy(&book, rental.CopyID, models.BookReturnPending); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
+		return
+	}
+	rental, err := models.AddRentalEvent(rental.ID, models.RentalEvent{Type: models.RentalEventReturnMarked, At: now, ActorID: user.ID, Note: message})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rental"})
+		return
+	}
+
+	body := fmt.Sprintf("%s says they have returned \"%s\".", user.Name, book.Title)
+	if message != "" {
+		body += "\n\nTheir message:\n" + message
+	}
+	notifyUser(rental.OwnerID, book.Title+" was returned", body+"\n\nPlease confirm you have it back so it can be lent again.")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Marked as returned. The owner will confirm receipt", "rental": rental})
+}
+
+// ConfirmReturn lets the owner confirm they have the book back, which ends
+// the rental and offers the copy to the first seeker on the book's waitlist,
+// or makes it available again if nobody is waiting
+func ConfirmReturn(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	message, ok := bindRequestMessage(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	rentalLock.Lock()
+	rental, book, ended, ok := endReturnedRental(c, user, message, now)
+	rentalLock.Unlock()
+
+	// The deposit is released once the rental has ended, as the payment
+	// provider is called without holding rentalLock
+	if ended {
+		if err := releaseDeposit(rental, now); err != nil {
+			log.Printf("Failed to release the deposit of rental %s: %v", rental.ID, err)
+		}
+	}
+	if !ok {
+		return
+	}
+
+	notifyUser(rental.RenterID, "Return of "+book.Title+" confirmed",
+		withMessage(fmt.Sprintf("The owner has confirmed they have \"%s\" back. Thanks for returning it!", book.Title), message))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Return confirmed", "rental": rental})
+}
+
+// endReturnedRental ends the rental named in the URL once the renter has
+// marked it returned and hands the copy on. It reports whether the rental
+// ended and whether everything succeeded, writing the error response if not.
+// Callers hold rentalLock.
+func endReturnedRental(c *gin.Context, user models.User, message string, now time.Time) (rental models.Rental, book models.Book, ended, ok bool) {
+	rental, book, ok = loadActiveRental(c, user, policy.ConfirmReturn)
+	if !ok {
+		return rental, book, false, false
+	}
+	if book.Copy(rental.CopyID).Status != models.BookReturnPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "The renter hasn't marked this book as returned yet"})
+		return rental, book, false, false
+	}
+
+	rental, err := models.EndRental(rental.ID, models.RentalReturned, models.RentalEvent{Type: models.RentalEventReturned, At: now, ActorID: user.ID, Note: message})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rental"})
+		return rental, book, false, false
+	}
+	book.Copy(rental.CopyID).RenterID = ""
+	if err := releaseCopy(&book, rental.CopyID, now); err != nil {
+		log.Printf("Failed to release copy %s of book %s after rental %s was returned: %v", rental.CopyID, book.ID, rental.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
+		return rental, book, true, false
+	}
+	return rental, book, true, true
+}
+
+// loadActiveRental loads the rental named in the URL and the book it is for,
+// checking the user may perform the action and that the book still has the
+// rented copy. It writes the error response and returns false if not.
+func loadActiveRental(c *gin.Context, user models.User, action policy.Action) (models.Rental, models.Book, bool) {
+	rental, exists := models.GetRentalByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rental not found"})
+		return models.Rental{}, models.Book{}, false
+	}
+	if err := policy.Check(user, action, rental); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return models.Rental{}, models.Book{}, false
+	}
+	if !rental.Active() {
+		c.JSON(http.StatusConflict, gin.H{"error": "This rental has ended"})
+		return models.Rental{}, models.Book{}, false
+	}
+	book, exists := models.GetBookByID(rental.BookID)
+	if !exists || book.Copy(rental.CopyID) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return models.Rental{}, models.Book{}, false
+	}
+	return rental, book, true
+}
+
+// startRental records the loan of the requested copy to the seeker of an
+// approved request, at the book's current price and with the payment holding
+// its deposit, if any
+func startRental(book models.Book, request models.RentalRequest, depositPaymentID string, now time.Time) (models.Rental, error) {
+	id, err := generateID()
+	if err != nil {
+		return models.Rental{}, err
+	}
+	dueAt := now.AddDate(0, 0, loanPeriodDays(book))
+	rental := models.Rental{
+		ID:        id,
+		BookID:    book.ID,
+		CopyID:    request.CopyID,
+		BookTitle: book.Title,
+		OwnerID:   book.OwnerID,
+		RenterID:  request.SeekerID,
+		RequestID: request.ID,
+		StartedAt: now,
+		DueAt:     &dueAt,
+		Pricing:   book.Pricing,
+
+		DepositPaymentID: depositPaymentID,
+		History: []models.RentalEvent{{
+			Type:    models.RentalEventStarted,
+			At:      now,
+			ActorID: book.OwnerID,
+			Note:    request.Response,
+		}},
+	}
+	return rental, models.SaveRental(rental)
+}
+
+// loanPeriodDays is how many days a rental of the book lasts
+func loanPeriodDays(book models.Book) int {
+	if book.LoanPeriodDays > 0 {
+		return book.LoanPeriodDays
+	}
+	return config.Get().DefaultLoanDays
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/payments"
+)
+
+// blockingRefunds is a payment provider whose refunds wait until the test
+// lets them through
+type blockingRefunds struct {
+	*payments.Fake
+	started chan string
+	proceed chan struct{}
+}
+
+func (p *blockingRefunds) Refund(ctx context.Context, paymentID string, amount int64) (payments.Payment, error) {
+	p.started <- paymentID
+	<-p.proceed
+	return payments.Payment{ID: paymentID, Amount: amount, Status: payments.StatusReleased}, nil
+}
+
+// saveTestRental lends a copy of a new book with a deposit from owner to
+// renter, with the copy in the given status
+func saveTestRental(t *testing.T, owner, renter models.User, copyStatus string) (models.Book, models.Rental) {
+	t.Helper()
+	bookID, err := generateID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pricing := &models.Pricing{Currency: "USD", Model: models.PricePerLoan, Deposit: 1000}
+	book := models.Book{
+		ID:          bookID,
+		Title:       "Middlemarch",
+		Author:      "George Eliot",
+		Location:    "Leeds",
+		ContactInfo: "owner@example.com",
+		OwnerID:     owner.ID,
+		Status:      copyStatus,
+		Copies:      []models.BookCopy{{ID: "1", Status: copyStatus, RenterID: renter.ID}},
+		Pricing:     pricing,
+	}
+	if err := models.SaveBook(book); err != nil {
+		t.Fatal(err)
+	}
+
+	rentalID, err := generateID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	dueAt := now.AddDate(0, 0, 14)
+	rental := models.Rental{
+		ID:               rentalID,
+		BookID:           book.ID,
+		CopyID:           "1",
+		BookTitle:        book.Title,
+		OwnerID:          owner.ID,
+		RenterID:         renter.ID,
+		StartedAt:        now,
+		DueAt:            &dueAt,
+		Pricing:          pricing,
+		DepositPaymentID: "pay_" + rentalID,
+	}
+	if err := models.SaveRental(rental); err != nil {
+		t.Fatal(err)
+	}
+	return book, rental
+}
+
+func TestConfirmReturnReleasesDepositOutsideRentalLock(t *testing.T) {
+	useTempDataDir(t)
+	gin.SetMode(gin.TestMode)
+	owner := saveTestUser(t, models.User{Name: "Owner", Email: "lender@example.com", EmailVerified: true})
+	renter := saveTestUser(t, models.User{Name: "Renter", Email: "borrower@example.com", EmailVerified: true})
+	_, rental := saveTestRental(t, owner, renter, models.BookReturnPending)
+
+	provider := &blockingRefunds{Fake: payments.NewFake(), started: make(chan string, 1), proceed: make(chan struct{})}
+	payments.SetProvider(provider)
+	t.Cleanup(func() { payments.SetProvider(payments.NewFake()) })
+
+	router := gin.New()
+	router.POST("/api/rentals/:id/confirm-return", func(c *gin.Context) { c.Set("user", owner) }, ConfirmReturn)
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postJSON(router, "/api/rentals/"+rental.ID+"/confirm-return", gin.H{}) }()
+
+	select {
+	case <-provider.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the deposit was never released")
+	}
+	if !rentalLock.TryLock() {
+		close(provider.proceed)
+		t.Fatal("rentalLock is held while the payment provider releases the deposit")
+	}
+	rentalLock.Unlock()
+	close(provider.proceed)
+
+	if rec := <-done; rec.Code != http.StatusOK {
+		t.Fatalf("confirming the return answered %d: %s", rec.Code, rec.Body)
+	}
+	if _, released := models.GetLedgerTransaction(models.LedgerDepositReleased + ":" + rental.ID); !released {
+		t.Error("the released deposit was not recorded in the ledger")
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/validation"
+)
+
+// isSelfAssignableRole reports whether users may give themselves the role.
+// Admin is granted only through the promote-admin command.
+func isSelfAssignableRole(role string) bool {
+	return role == models.RoleOwner || role == models.RoleSeeker
+}
+
+// AddRole gives the current user another capability, such as letting a seeker
+// also list books as an owner
+func AddRole(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	if !isSelfAssignableRole(body.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be either 'owner' or 'seeker'"})
+		return
+	}
+	if user.HasRole(body.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You already have this role"})
+		return
+	}
+
+	user.AddRole(body.Role)
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role added successfully", "user": user.WithoutSecrets()})
+}
+
+// RemoveRole drops a capability from the current user. Users must keep at
+// least one of the owner and seeker roles.
+func RemoveRole(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	role := c.Param("role")
+	if !isSelfAssignableRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be either 'owner' or 'seeker'"})
+		return
+	}
+	if !user.HasRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You don't have this role"})
+		return
+	}
+
+	user.RemoveRole(role)
+	if !user.HasRole(models.RoleOwner) && !user.HasRole(models.RoleSeeker) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You must keep at least one of the owner and seeker roles"})
+		return
+	}
+
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role removed successfully", "user": user.WithoutSecrets()})
+}
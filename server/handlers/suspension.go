@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/middleware"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/validation"
+)
+
+// SuspendUser suspends a user with a reason and an optional expiry (admin
+// only). The user is signed out everywhere, can't sign in again and their
+// listings are hidden until the suspension ends or is lifted.
+func SuspendUser(c *gin.Context) {
+	adminObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	admin := adminObj.(models.User)
+
+	var body struct {
+		Reason string     `json:"reason" binding:"required"`
+		Until  *time.Time `json:"until"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+	now := time.Now()
+	if body.Until != nil && !body.Until.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The suspension must end in the future"})
+		return
+	}
+
+	user, found := models.GetUserByID(c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.ID == admin.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot suspend your own account"})
+		return
+	}
+
+	user.Suspension = &models.Suspension{
+		Reason:      body.Reason,
+		Until:       body.Until,
+		SuspendedBy: admin.ID,
+		SuspendedAt: now,
+	}
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+	middleware.RemoveUserSessions(user.ID, "")
+
+	notifyAccountChange(user.Email, "Your NextChapter account has been suspended",
+		fmt.Sprintf("Hi %s,\n\n%s.\n\nWhile suspended you can't sign in and your listings are hidden.", user.Name, middleware.SuspensionMessage(user)))
+
+	c.JSON(http.StatusOK, gin.H{"message": "User suspended", "user": user.WithoutSecrets()})
+}
+
+// ReinstateUser lifts a user's suspension (admin only). Their listings
+// reappear and they can sign in again.
+func ReinstateUser(c *gin.Context) {
+	user, found := models.GetUserByID(c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Suspension == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User is not suspended"})
+		return
+	}
+
+	user.Suspension = nil
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	notifyAccountChange(user.Email, "Your NextChapter account has been reinstated",
+		fmt.Sprintf("Hi %s,\n\nYour account suspension has been lifted. You can sign in again and your listings are visible.", user.Name))
+
+	c.JSON(http.StatusOK, gin.H{"message": "User reinstated", "user": user.WithoutSecrets()})
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/totp"
+	"nextchapter.com/m/validation"
+)
+
+const (
+	totpIssuer             = "NextChapter"
+	pendingLoginTTL        = 5 * time.Minute
+	pendingLoginMaxAttempt = 5
+	recoveryCodeCount      = 10
+)
+
+// pendingLogin is a login that passed the password check and is waiting for
+// the second factor
+type pendingLogin struct {
+	userID    string
+	expiresAt time.Time
+	attempts  int
+}
+
+// In-memory store of pending logins
+var (
+	pendingLogins    = make(map[string]*pendingLogin) // maps pending token to login
+	pendingLoginLock sync.Mutex
+)
+
+// createPendingLogin registers a pending login for the user and returns its token
+func createPendingLogin(userID string) (string, error) {
+	token, err := generateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	pendingLoginLock.Lock()
+	defer pendingLoginLock.Unlock()
+	now := time.Now()
+	for t, p := range pendingLogins {
+		if now.After(p.expiresAt) {
+			delete(pendingLogins, t)
+		}
+	}
+	pendingLogins[token] = &pendingLogin{userID: userID, expiresAt: now.Add(pendingLoginTTL)}
+	return token, nil
+}
+
+// lookupPendingLogin returns the user ID for a pending login token and counts
+// the attempt against it. Expired or exhausted tokens are discarded.
+func lookupPendingLogin(token string) (string, bool) {
+	pendingLoginLock.Lock()
+	defer pendingLoginLock.Unlock()
+	p, exists := pendingLogins[token]
+	if !exists {
+		return "", false
+	}
+	p.attempts++
+	if time.Now().After(p.expiresAt) || p.attempts > pendingLoginMaxAttempt {
+		delete(pendingLogins, token)
+		return "", false
+	}
+	return p.userID, true
+}
+
+// removePendingLogin discards a pending login token
+func removePendingLogin(token string) {
+	pendingLoginLock.Lock()
+	defer pendingLoginLock.Unlock()
+	delete(pendingLogins, token)
+}
+
+// VerifyTwoFactorLogin completes a login for a user with 2FA enabled, using
+// either a TOTP code or a one-time recovery code
+func VerifyTwoFactorLogin(c *gin.Context) {
+	var body struct {
+		PendingToken string `json:"pendingToken" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+	if body.Code == "" && body.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A code or recovery code is required"})
+		return
+	}
+
+	userID, ok := lookupPendingLogin(body.PendingToken)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+
+	user, found := models.GetUserByID(userID)
+	if !found || !user.TOTPEnabled {
+		removePendingLogin(body.PendingToken)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+
+	// Second factor guesses count towards the same lockout as passwords
+	if loginLockedOut(c, user.Email) {
+		return
+	}
+	if !checkSecondFactor(&user, body.Code, body.RecoveryCode) {
+		recordLoginFailure(c, user.Email, user.ID, "wrong two-factor code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	removePendingLogin(body.PendingToken)
+	startSession(c, user)
+}
+
+// SetupTwoFactor generates a new TOTP secret for the current user. The secret
+// only becomes active once a code from it is confirmed with EnableTwoFactor.
+func SetupTwoFactor(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	// The client renders the otpauth URI as a QR code for authenticator apps
+	c.JSON(http.StatusOK, gin.H{
+		"secret":     secret,
+		"otpauthUri": totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// EnableTwoFactor turns on 2FA after the user proves their authenticator app
+// produces valid codes, and returns a fresh set of recovery codes
+func EnableTwoFactor(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start two-factor setup first"})
+		return
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, body.Code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodes = hashes
+
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recoveryCodes": codes})
+}
+
+// DisableTwoFactor turns off 2FA for the current user after checking their
+// password and a code
+func DisableTwoFactor(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+		Code            string `json:"code"`
+		RecoveryCode    string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !confirmCurrentPassword(c, user, body.CurrentPassword) {
+		return
+	}
+	if !confirmSecondFactor(c, &user, body.Code, body.RecoveryCode) {
+		return
+	}
+
+	clearTwoFactor(&user)
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	notifyAccountChange(user.Email, "Two-factor authentication was turned off",
+		fmt.Sprintf("Hi %s,\n\nTwo-factor authentication was just turned off for your NextChapter account.\n\nIf this wasn't you, change your password and contact support right away.", user.Name))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes after checking a TOTP code
+func RegenerateRecoveryCodes(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	var body struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !confirmSecondFactor(c, &user, body.Code, "") {
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	user.RecoveryCodes = hashes
+
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// ResetTwoFactor disables 2FA for another user, for account recovery when the
+// user has lost both their authenticator and their recovery codes
+func ResetTwoFactor(c *gin.Context) {
+	user, exists := models.GetUserByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	clearTwoFactor(&user)
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset"})
+}
+
+// confirmSecondFactor checks a code the signed in user entered to change their
+// 2FA settings, writing an error response if it is wrong. Wrong codes count
+// towards the login lockout as they do at login, so a stolen session can't be
+// used to guess them.
+func confirmSecondFactor(c *gin.Context, user *models.User, code, recoveryCode string) bool {
+	if loginLockedOut(c, user.Email) {
+		return false
+	}
+	if !checkSecondFactor(user, code, recoveryCode) {
+		recordLoginFailure(c, user.Email, user.ID, "wrong two-factor code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return false
+	}
+	return true
+}
+
+// checkSecondFactor validates a TOTP code or consumes a recovery code. The user
+// is updated in place and must be saved by the caller on success.
+func checkSecondFactor(user *models.User, code, recoveryCode string) bool {
+	if code != "" {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+		if ok {
+			user.TOTPLastStep = step
+		}
+		return ok
+	}
+
+	if recoveryCode != "" {
+		hash := hashRecoveryCode(recoveryCode)
+		for i, stored := range user.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+				// Recovery codes are single use
+				user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clearTwoFactor removes all 2FA settings from the user
+func clearTwoFactor(user *models.User) {
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+}
+
+// generateRecoveryCodes returns a set of recovery codes and their hashes for storage
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"nextchapter.com/m/models"
+	"nextchapter.com/m/password"
+	"nextchapter.com/m/validation"
 )
 
 // UpdateUser updates a user's profile information
@@ -20,35 +23,57 @@ func UpdateUser(c *gin.Context) {
 	// Bind updated user data
 	var updatedUser models.User
 	if err := c.ShouldBindJSON(&updatedUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
 		return
 	}
 
-	// Keep the same ID and role (these cannot be changed)
+	// Keep the same ID and roles (roles change through their own endpoints)
 	updatedUser.ID = currentUser.ID
-	updatedUser.Role = currentUser.Role
+	updatedUser.Roles = currentUser.Roles
 
-	// If password is empty, keep the current password
-	if updatedUser.Password == "" {
-		updatedUser.Password = currentUser.Password
+	// Password and email changes need the current password and go through
+	// ChangePassword and ChangeEmail instead
+	if updatedUser.Password != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use POST /api/me/password to change your password"})
+		return
+	}
+	if updatedUser.Email != "" && updatedUser.Email != currentUser.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use POST /api/me/email to change your email"})
+		return
 	}
+	updatedUser.Password = currentUser.Password
+	updatedUser.Email = currentUser.Email
 
-	// Check if the email is being changed and if it already exists
-	if updatedUser.Email != currentUser.Email {
-		if _, found := models.GetUserByEmail(updatedUser.Email); found {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Email already in use"})
-			return
-		}
+	// Privacy settings are optional, keep the current ones if none are sent
+	if updatedUser.Privacy == (models.Privacy{}) {
+		updatedUser.Privacy = currentUser.Privacy
+	}
+
+	updatedUser.MobileNumber = validation.NormalizePhone(updatedUser.MobileNumber)
+	if errs := validation.Struct(updatedUser); len(errs) > 0 {
+		respondFieldErrors(c, "Please correct the highlighted fields", errs)
+		return
 	}
 
+	// Verification, 2FA and payment state are managed by the server
+	updatedUser.EmailVerified = currentUser.EmailVerified
+	updatedUser.PendingEmail = currentUser.PendingEmail
+	updatedUser.TOTPEnabled = currentUser.TOTPEnabled
+	updatedUser.TOTPSecret = currentUser.TOTPSecret
+	updatedUser.TOTPLastStep = currentUser.TOTPLastStep
+	updatedUser.RecoveryCodes = currentUser.RecoveryCodes
+	updatedUser.Identities = currentUser.Identities
+	updatedUser.Suspension = currentUser.Suspension
+	updatedUser.PaymentCustomerID = currentUser.PaymentCustomerID
+
 	// Save the updated user
 	if err := models.SaveUser(updatedUser); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
 
-	// Don't return the password in the response
-	updatedUser.Password = ""
+	// Don't return the password or 2FA secrets in the response
+	updatedUser = updatedUser.WithoutSecrets()
 	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully", "user": updatedUser})
 }
 
@@ -61,27 +86,86 @@ func GetUserProfile(c *gin.Context) {
 		return
 	}
 
-	// Create a public profile with limited information
+	// Create a public profile, with contact details only as far as the
+	// user's privacy settings allow
 	publicProfile := struct {
-		ID          string `json:"id"`
-		Name        string `json:"name"`
-		Role        string `json:"role"`
-		ContactInfo string `json:"contactInfo"`
+		ID           string   `json:"id"`
+		Name         string   `json:"name"`
+		Roles        []string `json:"roles"`
+		ContactInfo  string   `json:"contactInfo"`
+		Email        string   `json:"email,omitempty"`
+		MobileNumber string   `json:"mobileNumber,omitempty"`
+		Address      string   `json:"address,omitempty"`
 	}{
-		ID:          user.ID,
-		Name:        user.Name,
-		Role:        user.Role,
-		ContactInfo: user.Email, // Use email as contact info
+		ID:    user.ID,
+		Name:  user.Name,
+		Roles: user.Roles,
+	}
+	me := viewer(c)
+	if canSeeContactField(me, user, user.Privacy.Email) {
+		publicProfile.Email = user.Email
+		publicProfile.ContactInfo = user.Email // Use email as contact info
+	}
+	if canSeeContactField(me, user, user.Privacy.MobileNumber) {
+		publicProfile.MobileNumber = user.MobileNumber
+	}
+	if canSeeContactField(me, user, user.Privacy.Address) {
+		publicProfile.Address = user.Address
 	}
 
 	c.JSON(http.StatusOK, gin.H{"user": publicProfile})
 }
 
+// registerRequest is what a new user may choose at sign-up. Everything else
+// on the account, such as verification, 2FA and payment state, is set by the
+// server.
+type registerRequest struct {
+	Name         string         `json:"name"`
+	Email        string         `json:"email"`
+	Password     string         `json:"password"`
+	MobileNumber string         `json:"mobileNumber"`
+	Address      string         `json:"address"`
+	Roles        []string       `json:"roles"`
+	Privacy      models.Privacy `json:"privacy"`
+}
+
 // RegisterUserWithID handles user registration with ID generation
 func RegisterUserWithID(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var req registerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+	user := models.User{
+		Name:         req.Name,
+		Email:        req.Email,
+		Password:     req.Password,
+		MobileNumber: req.MobileNumber,
+		Address:      req.Address,
+		Privacy:      req.Privacy,
+	}
+
+	// Check the profile fields, password strength and roles together so
+	// every problem is reported at once
+	user.MobileNumber = validation.NormalizePhone(user.MobileNumber)
+	errs := validation.Struct(user)
+	errs = append(errs, password.Check("password", user.Password, user.Name, user.Email)...)
+
+	// Validate roles, accepting "owner", "seeker" or both
+	roles := req.Roles
+	for _, role := range roles {
+		if !isSelfAssignableRole(role) {
+			errs = append(errs, validation.FieldError{Field: "roles", Code: "invalid_choice", Message: "Invalid role. Must be either 'owner' or 'seeker'"})
+			break
+		}
+		user.AddRole(role)
+	}
+	if len(roles) == 0 {
+		errs = append(errs, validation.FieldError{Field: "roles", Code: "required", Message: "At least one role is required"})
+	}
+
+	if len(errs) > 0 {
+		respondFieldErrors(c, "Please correct the highlighted fields", errs)
 		return
 	}
 
@@ -100,11 +184,8 @@ func RegisterUserWithID(c *gin.Context) {
 	}
 	user.ID = id
 
-	// Validate role
-	if user.Role != models.RoleOwner && user.Role != models.RoleSeeker {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be either 'owner' or 'seeker'"})
-		return
-	}
+	// New accounts stay unverified until the emailed link is confirmed
+	user.EmailVerified = false
 
 	// Save the user
 	err = models.SaveUser(user)
@@ -113,34 +194,30 @@ func RegisterUserWithID(c *gin.Context) {
 		return
 	}
 
-	// Don't return the password in the response
-	user.Password = ""
-	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully", "user": user})
+	// The account is usable without the email, and the link can be resent later
+	if err := sendVerificationEmail(user, user.Email); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+	}
+
+	// Don't return the password or 2FA secrets in the response
+	user = user.WithoutSecrets()
+	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully. Check your email to verify your account", "user": user})
 }
 
 // ListUsers returns a list of all users (admin only)
 func ListUsers(c *gin.Context) {
-	// Get the current user from the context
-	userObj, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
-		return
-	}
-	currentUser := userObj.(models.User)
-
-	// Check if user is an owner (for admin purposes)
-	if currentUser.Role != models.RoleOwner {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can view all users"})
-		return
-	}
-
-	// Get all users
-	allUsers := []models.User{}
-	for _, user := range models.Users {
-		// Don't expose passwords
-		user.Password = ""
-		allUsers = append(allUsers, user)
+	allUsers := models.GetAllUsers()
+	for i, user := range allUsers {
+		// Don't expose passwords or 2FA secrets
+		allUsers[i] = user.WithoutSecrets()
 	}
 
 	c.JSON(http.StatusOK, gin.H{"users": allUsers})
 }
+
+// ListLoginAttempts returns recorded failed and blocked logins (admin only).
+// Results can be filtered with the email and ip query parameters.
+func ListLoginAttempts(c *gin.Context) {
+	attempts := models.GetLoginAttempts(c.Query("email"), c.Query("ip"))
+	c.JSON(http.StatusOK, gin.H{"attempts": attempts})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/models"
+)
+
+func TestRegisterIgnoresServerManagedFields(t *testing.T) {
+	useTempDataDir(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/register", RegisterUserWithID)
+	suffix, err := generateID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := "signup-" + suffix + "@example.com"
+
+	rec := postJSON(router, "/api/register", gin.H{
+		"name":              "Reader",
+		"email":             email,
+		"password":          "a long and unusual passphrase",
+		"roles":             []string{models.RoleSeeker},
+		"emailVerified":     true,
+		"totpEnabled":       true,
+		"paymentCustomerId": "cus_someone_else",
+		"suspension":        gin.H{"reason": "none"},
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("registration answered %d: %s", rec.Code, rec.Body)
+	}
+	user, found := models.GetUserByEmail(email)
+	if !found {
+		t.Fatal("user was not saved")
+	}
+	if user.PaymentCustomerID != "" || user.EmailVerified || user.TOTPEnabled || user.Suspension != nil {
+		t.Errorf("registration set server-managed fields: %+v", user)
+	}
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/validation"
+)
+
+// respondFieldErrors writes a 400 response listing the problems with each field
+func respondFieldErrors(c *gin.Context, message string, errs []validation.FieldError) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": message, "errors": errs})
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/validation"
+)
+
+func TestBindErrorsAreFieldErrors(t *testing.T) {
+	useTempDataDir(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/login", Login)
+	router.POST("/api/login/magic", RequestMagicLink)
+	router.POST("/api/verify-email", VerifyEmail)
+
+	for _, path := range []string{"/api/login", "/api/login/magic", "/api/verify-email"} {
+		rec := postJSON(router, path, gin.H{})
+		var body struct {
+			Error  string                  `json:"error"`
+			Errors []validation.FieldError `json:"errors"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusBadRequest || body.Error != "Invalid request body" || len(body.Errors) == 0 || body.Errors[0].Code != "required" {
+			t.Errorf("%s answered %d: %s", path, rec.Code, rec.Body)
+		}
+	}
+
+	rec := postJSON(router, "/api/verify-email", gin.H{"token": "no-such-token"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown verification token answered %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/mailer"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/validation"
+)
+
+// VerifyEmail confirms an email address using the token from a verification link.
+// For a new account this marks the account verified; for an email change it
+// replaces the user's address with the confirmed one.
+func VerifyEmail(c *gin.Context) {
+	var body struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondFieldErrors(c, "Invalid request body", validation.FromBindError(err))
+		return
+	}
+
+	verification, err := models.ConsumeEmailVerification(body.Token)
+	if errors.Is(err, models.ErrInvalidVerification) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This verification link is invalid or has expired"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to consume email verification: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	user, found := models.GetUserByID(verification.UserID)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// An email change only takes effect once the new address is confirmed
+	if verification.Email != user.Email {
+		if verification.Email != user.PendingEmail {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This email change has been superseded"})
+			return
+		}
+		if other, found := models.GetUserByEmail(verification.Email); found && other.ID != user.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email already in use"})
+			return
+		}
+		user.Email = verification.Email
+	}
+	user.PendingEmail = ""
+	user.EmailVerified = true
+
+	if err := models.SaveUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	user = user.WithoutSecrets()
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully", "user": user})
+}
+
+// ResendVerification issues a fresh verification link for the current user's
+// unconfirmed address, or for a pending email change
+func ResendVerification(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	email := user.PendingEmail
+	if email == "" {
+		if user.EmailVerified {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+			return
+		}
+		email = user.Email
+	}
+
+	if err := sendVerificationEmail(user, email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// sendVerificationEmail creates a verification token for the given address
+// and mails the confirmation link to it
+func sendVerificationEmail(user models.User, email string) error {
+	token, err := generateSessionID()
+	if err != nil {
+		return err
+	}
+
+	verification := models.EmailVerification{
+		Token:     token,
+		UserID:    user.ID,
+		Email:     email,
+		ExpiresAt: time.Now().Add(models.EmailVerificationTTL),
+	}
+	if err := models.SaveEmailVerification(verification); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", config.Get().AppURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm your email address for NextChapter by opening the link below:\n\n%s\n\nThe link expires in 24 hours.", user.Name, link)
+	return mailer.Send(email, "Confirm your email address", body)
+}
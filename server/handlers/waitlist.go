@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/policy"
+)
+
+// waitlistPlace is a waitlist entry with its place in the queue, counting
+// from 1
+type waitlistPlace struct {
+	models.WaitlistEntry
+	Position int `json:"position"`
+}
+
+// waitlistOpen reports whether seekers must queue for the book rather than
+// request it: while none of its copies can be requested and some are on loan
+// or held, or while readers who queued for it are still waiting their turn
+func waitlistOpen(book models.Book) bool {
+	return models.IsWaitlisted(book.Status) || models.HasWaitingSeekers(book.ID)
+}
+
+// queuePlaces numbers a book's queue
+func queuePlaces(bookID string) []waitlistPlace {
+	queue := models.GetWaitlist(bookID)
+	places := make([]waitlistPlace, len(queue))
+	for i, entry := range queue {
+		places[i] = waitlistPlace{WaitlistEntry: entry, Position: i + 1}
+	}
+	return places
+}
+
+// placeOf finds the entry's place in its book's queue
+func placeOf(entry models.WaitlistEntry) waitlistPlace {
+	for _, place := range queuePlaces(entry.BookID) {
+		if place.ID == entry.ID {
+			return place
+		}
+	}
+	return waitlistPlace{WaitlistEntry: entry}
+}
+
+// JoinWaitlist puts the seeker at the back of the queue for a book whose
+// copies are on loan. As copies come back, they are offered to the queue in
+// the order they joined.
+func JoinWaitlist(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	book, exists := models.GetBookByID(c.Param("id"))
+	if !exists || ownerSuspended(book) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+	if err := policy.Check(user, policy.JoinWaitlist, book); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !waitlistOpen(book) {
+		if models.IsRequestable(book.Status) {
+			c.JSON(http.StatusConflict, gin.H{"error": "This book isn't on loan. Request it instead"})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Book is not available for rent"})
+		}
+		return
+	}
+	if book.RentedBy(user.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You are currently renting this book"})
+		return
+	}
+	if _, queued := models.GetWaitlistEntry(book.ID, user.ID); queued {
+		c.JSON(http.StatusConflict, gin.H{"error": "You are already on the waitlist for this book"})
+		return
+	}
+	if models.HasPendingRentalRequest(book.ID, user.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already requested this book"})
+		return
+	}
+
+	id, err := generateID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate ID"})
+		return
+	}
+	entry := models.WaitlistEntry{
+		ID:       id,
+		BookID:   book.ID,
+		OwnerID:  book.OwnerID,
+		SeekerID: user.ID,
+		Status:   models.WaitlistWaiting,
+		JoinedAt: time.Now(),
+	}
+	if err := models.SaveWaitlistEntry(entry); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join waitlist"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Added to the waitlist", "entry": placeOf(entry)})
+}
+
+// LeaveWaitlist takes the seeker out of the queue for a book. If the book was
+// being held for them, it is offered to the next in line.
+func LeaveWaitlist(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	entry, exists := models.GetWaitlistEntry(c.Param("id"), user.ID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not on the waitlist for this book"})
+		return
+	}
+	entry, err := closeWaitlistEntry(entry, models.WaitlistLeft, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to leave waitlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Removed from the waitlist", "entry": entry})
+}
+
+// GetWaitlistPosition returns the seeker's place in the queue for a book
+func GetWaitlistPosition(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	entry, exists := models.GetWaitlistEntry(c.Param("id"), user.ID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not on the waitlist for this book"})
+		return
+	}
+
+	place := placeOf(entry)
+	c.JSON(http.StatusOK, gin.H{"entry": place, "position": place.Position, "queueLength": len(models.GetWaitlist(entry.BookID))})
+}
+
+// ListMyWaitlists returns every queue the current user is in, with their
+// place in each
+func ListMyWaitlists(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	entries := models.GetWaitlistBySeeker(user.ID)
+	places := make([]waitlistPlace, len(entries))
+	for i, entry := range entries {
+		places[i] = placeOf(entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"waitlist": places})
+}
+
+// AcceptWaitlistOffer takes up the book held for the seeker, sending the owner
+// a rental request for it
+func AcceptWaitlistOffer(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	message, ok := bindRequestMessage(c)
+	if !ok {
+		return
+	}
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	entry, book, ok := loadWaitlistOffer(c, user)
+	if !ok {
+		return
+	}
+	if err := policy.Check(user, policy.RequestBook, book); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if !requirePaymentMethod(c, user, book) {
+		return
+	}
+
+	// The request goes first: if it can't be saved the offer is still open
+	// and the copy still held, so the seeker can try again. Once it is saved
+	// the copy is requested, and closing the entry no longer releases it.
+	now := time.Now()
+	request, err := createRentalRequest(user, &book, entry.CopyID, message, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save request"})
+		return
+	}
+	if accepted, err := models.CloseWaitlistEntry(entry.ID, models.WaitlistAccepted, now); err != nil {
+		log.Printf("Failed to close waitlist entry %s after request %s was sent: %v", entry.ID, request.ID, err)
+	} else {
+		entry = accepted
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Request sent to the owner", "entry": entry, "request": request})
+}
+
+// DeclineWaitlistOffer turns down the book held for the seeker, passing it to
+// the next in line
+func DeclineWaitlistOffer(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	entry, _, ok := loadWaitlistOffer(c, user)
+	if !ok {
+		return
+	}
+	entry, err := closeWaitlistEntry(entry, models.WaitlistDeclined, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update waitlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Offer declined", "entry": entry})
+}
+
+// loadWaitlistOffer loads the offer held for the user on the book named in
+// the URL. It writes the error response and returns false if there isn't one.
+func loadWaitlistOffer(c *gin.Context, user models.User) (models.WaitlistEntry, models.Book, bool) {
+	entry, exists := models.GetWaitlistEntry(c.Param("id"), user.ID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not on the waitlist for this book"})
+		return models.WaitlistEntry{}, models.Book{}, false
+	}
+	if entry.Status != models.WaitlistOffered {
+		c.JSON(http.StatusConflict, gin.H{"error": "This book hasn't been offered to you yet"})
+		return models.WaitlistEntry{}, models.Book{}, false
+	}
+	book, exists := models.GetBookByID(entry.BookID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return models.WaitlistEntry{}, models.Book{}, false
+	}
+	return entry, book, true
+}
+
+// GetBookWaitlist returns the queue for one of the owner's books
+func GetBookWaitlist(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	book, exists := models.GetBookByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+	if err := policy.Check(user, policy.ManageWaitlist, book); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"waitlist": queuePlaces(book.ID)})
+}
+
+// RemoveWaitlistEntry lets the owner take a seeker off the queue for their
+// book. If the book was being held for them, it is offered to the next in
+// line.
+func RemoveWaitlistEntry(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user := userObj.(models.User)
+
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	book, exists := models.GetBookByID(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+	if err := policy.Check(user, policy.ManageWaitlist, book); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	entry, exists := models.GetWaitlistEntryByID(c.Param("entryId"))
+	if !exists || entry.BookID != book.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Waitlist entry not found"})
+		return
+	}
+	if !entry.InQueue() {
+		c.JSON(http.StatusConflict, gin.H{"error": "This reader is no longer on the waitlist"})
+		return
+	}
+
+	entry, err := closeWaitlistEntry(entry, models.WaitlistRemoved, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update waitlist"})
+		return
+	}
+	notifyUser(entry.SeekerID, "You were removed from the waitlist for "+book.Title,
+		fmt.Sprintf("The owner of \"%s\" has taken you off its waitlist.", book.Title))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Removed from the waitlist", "entry": entry})
+}
+
+// ExpireWaitlistOffers closes the offers that have run out by now and passes
+// each book to the next seeker in line. The reminder scheduler runs it.
+func ExpireWaitlistOffers(now time.Time) {
+	rentalLock.Lock()
+	defer rentalLock.Unlock()
+
+	for _, entry := range models.GetOpenOffers() {
+		if entry.OfferExpiresAt == nil || now.Before(*entry.OfferExpiresAt) {
+			continue
+		}
+		if _, err := closeWaitlistEntry(entry, models.WaitlistExpired, now); err != nil {
+			log.Printf("Failed to expire waitlist offer %s: %v", entry.ID, err)
+			continue
+		}
+		if book, exists := models.GetBookByID(entry.BookID); exists {
+			notifyUser(entry.SeekerID, "Your hold on "+book.Title+" has expired",
+				fmt.Sprintf("We held \"%s\" for you, but the offer ran out, so it has passed to the next reader.", book.Title))
+		}
+	}
+}
+
+// closeWaitlistEntry takes an entry out of its queue. If a copy was held for
+// the entry, it is released to the next in line. Callers hold rentalLock.
+func closeWaitlistEntry(entry models.WaitlistEntry, status string, now time.Time) (models.WaitlistEntry, error) {
+	wasOffered := entry.Status == models.WaitlistOffered
+	entry, err := models.CloseWaitlistEntry(entry.ID, status, now)
+	if err != nil {
+		return entry, err
+	}
+	if !wasOffered {
+		return entry, nil
+	}
+	book, exists := models.GetBookByID(entry.BookID)
+	if !exists {
+		return entry, nil
+	}
+	if bookCopy := book.Copy(entry.CopyID); bookCopy != nil && bookCopy.Status == models.BookOnHold {
+		if err := releaseCopy(&book, entry.CopyID, now); err != nil {
+			log.Printf("Failed to release copy %s of book %s after waitlist entry %s was %s: %v", entry.CopyID, book.ID, entry.ID, status, err)
+		}
+	}
+	return entry, nil
+}
+
+// releaseCopy offers a copy of a book that has come free to the first seeker
+// waiting for the book, holding it for them, or makes it available if nobody
+// is waiting. Callers hold rentalLock.
+func releaseCopy(book *models.Book, copyID string, now time.Time) error {
+	bookCopy := book.Copy(copyID)
+	if bookCopy == nil {
+		return fmt.Errorf("book %s has no copy %s", book.ID, copyID)
+	}
+	for _, entry := range models.GetWaitlist(book.ID) {
+		if entry.Status != models.WaitlistWaiting {
+			continue
+		}
+		expiresAt := now.Add(config.Get().WaitlistOfferWindow)
+		entry, err := models.OfferWaitlistEntry(entry.ID, copyID, now, expiresAt)
+		if err != nil {
+			return err
+		}
+		if bookCopy.Status != models.BookOnHold {
+			if err := transitionCopy(book, copyID, models.BookOnHold); err != nil {
+				return err
+			}
+		}
+		notifyUser(entry.SeekerID, book.Title+" is ready for you",
+			fmt.Sprintf("\"%s\" is back and you're first on its waitlist. We'll hold it for you until %s. Accept the offer to send the owner a request, or it will pass to the next reader.",
+				book.Title, expiresAt.Format("Monday 2 January 15:04")))
+		return nil
+	}
+	if bookCopy.Status == models.BookAvailable {
+		return nil
+	}
+	return transitionCopy(book, copyID, models.BookAvailable)
+}
+
+// offerFreeCopies offers the book's available copies to the seekers still
+// waiting for it, as when the owner adds a copy or puts copies back on the
+// market. Callers hold rentalLock.
+func offerFreeCopies(book *models.Book, now time.Time) error {
+	for _, bookCopy := range book.Copies {
+		if bookCopy.Status != models.BookAvailable {
+			continue
+		}
+		if err := releaseCopy(book, bookCopy.ID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeBookWaitlist removes everyone from the queue for a book that has been
+// deleted
+func closeBookWaitlist(book models.Book, now time.Time) {
+	for _, entry := range models.GetWaitlist(book.ID) {
+		if _, err := models.CloseWaitlistEntry(entry.ID, models.WaitlistRemoved, now); err != nil {
+			if !errors.Is(err, models.ErrNotInQueue) {
+				log.Printf("Failed to close waitlist entry %s: %v", entry.ID, err)
+			}
+			continue
+		}
+		notifyUser(entry.SeekerID, book.Title+" is no longer listed",
+			fmt.Sprintf("The owner has removed \"%s\", so its waitlist has closed.", book.Title))
+	}
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"log"
+	"sync"
+)
+
+// Mailer sends plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// LogMailer writes emails to the server log instead of delivering them
+type LogMailer struct{}
+
+// Send logs the email
+func (LogMailer) Send(to, subject, body string) error {
+	log.Printf("mail to=%q subject=%q\n%s", to, subject, body)
+	return nil
+}
+
+var (
+	current    Mailer = LogMailer{}
+	mailerLock sync.RWMutex
+)
+
+// SetMailer replaces the mailer used by Send
+func SetMailer(m Mailer) {
+	mailerLock.Lock()
+	defer mailerLock.Unlock()
+	current = m
+}
+
+// Send delivers an email through the configured mailer
+func Send(to, subject, body string) error {
+	mailerLock.RLock()
+	defer mailerLock.RUnlock()
+	return current.Send(to, subject, body)
+}
@@ -1,29 +1,54 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
 	"nextchapter.com/m/handlers"
 	"nextchapter.com/m/middleware"
 	"nextchapter.com/m/models"
+	"nextchapter.com/m/oidc"
+	"nextchapter.com/m/payments"
+	"nextchapter.com/m/policy"
+	"nextchapter.com/m/reminders"
 )
 
 func main() {
+	// Administrative commands run instead of the server
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
 	// Initialize the router
 	router := gin.Default()
 
+	// Only believe X-Forwarded-For from our own proxies, as client IPs key the
+	// login lockout
+	if err := router.SetTrustedProxies(config.Get().TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization, Accept, Origin"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "Accept", "Origin", middleware.CSRFHeader},
 		AllowCredentials: true,
 	}))
 
 	// set up the routes
 	SetupRoutes(router)
 
+	// Mark overdue rentals, send due date reminders, expire waitlist offers,
+	// charge late fees and retry failed deposit releases in the background
+	scheduler := reminders.NewScheduler()
+	scheduler.Tasks = append(scheduler.Tasks, handlers.ExpireWaitlistOffers, handlers.AccrueLateFees, handlers.RetryDepositReleases)
+	go scheduler.Run(context.Background())
+
 	// Start the server
 	log.Println("Server started on http://localhost:8080")
 	if err := router.Run(":8000"); err != nil {
@@ -35,40 +60,132 @@ func SetupRoutes(router *gin.Engine) {
 	// Initialize data store
 	models.InitializeDataStore()
 
+	// Load the identity providers users can sign in with
+	if err := oidc.LoadProviders(config.Get().OIDCProvidersFile); err != nil {
+		log.Printf("Error loading OIDC providers: %v", err)
+	}
+
+	// Choose how rental deposits are collected. The provider must be named,
+	// as the fake approves every deposit without charging anyone. Webhooks
+	// are only accepted from a real provider, as the fake has no secret to
+	// check them with.
+	switch cfg := config.Get(); cfg.PaymentProvider {
+	case "stripe":
+		if cfg.StripeSecretKey == "" || cfg.StripeWebhookSecret == "" {
+			log.Fatal("PAYMENT_PROVIDER=stripe needs STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET")
+		}
+		payments.SetProvider(payments.NewStripe(cfg.StripeAPIURL, cfg.StripeSecretKey, cfg.StripeWebhookSecret))
+		router.POST("/api/payments/webhook", handlers.PaymentWebhook)
+	case "fake":
+		log.Println("PAYMENT_PROVIDER is fake, deposits are authorized by a fake provider that moves no money and payment webhooks are disabled")
+	case "":
+		log.Fatal("PAYMENT_PROVIDER is not set, use stripe, or fake for development")
+	default:
+		log.Fatalf("Unknown PAYMENT_PROVIDER %q, use stripe or fake", cfg.PaymentProvider)
+	}
+
 	// Public routes
 	router.POST("/api/register", handlers.RegisterUserWithID)
 	router.POST("/api/login", handlers.Login)
-	router.GET("/api/books", handlers.GetAllBooks)
-	router.GET("/api/books/:id", handlers.GetBook)
-	router.GET("/api/search", handlers.SearchBooks)
+	router.POST("/api/login/2fa", handlers.VerifyTwoFactorLogin)
+	router.POST("/api/login/magic", handlers.RequestMagicLink)
+	router.POST("/api/login/magic/verify", handlers.VerifyMagicLink)
+	router.POST("/api/login/passkey/begin", handlers.BeginPasskeyLogin)
+	router.POST("/api/login/passkey/finish", handlers.FinishPasskeyLogin)
+	router.GET("/api/auth/oidc/:provider/login", handlers.OIDCLogin)
+	router.GET("/api/auth/oidc/:provider/callback", handlers.OIDCCallback)
+	router.POST("/api/verify-email", handlers.VerifyEmail)
+	router.GET("/api/books", middleware.OptionalAuth(), handlers.GetAllBooks)
+	router.GET("/api/books/:id", middleware.OptionalAuth(), handlers.GetBook)
+	router.GET("/api/search", middleware.OptionalAuth(), handlers.SearchBooks)
 
 	// Routes that require authentication
 	authenticated := router.Group("/api")
-	authenticated.Use(middleware.AuthRequired())
+	authenticated.Use(middleware.AuthRequired(), middleware.CSRFProtected())
 	{
 		// Auth routes
 		authenticated.GET("/me", handlers.GetCurrentUser)
+		authenticated.GET("/csrf-token", handlers.GetCSRFToken)
 		authenticated.POST("/logout", handlers.Logout)
 		authenticated.PUT("/me", handlers.UpdateUser)
+		authenticated.POST("/me/password", handlers.ChangePassword)
+		authenticated.POST("/me/email", handlers.ChangeEmail)
+		authenticated.POST("/verify-email/resend", handlers.ResendVerification)
+		authenticated.POST("/me/roles", handlers.AddRole)
+		authenticated.DELETE("/me/roles/:role", handlers.RemoveRole)
+
+		// Two-factor authentication routes
+		authenticated.POST("/me/2fa/setup", handlers.SetupTwoFactor)
+		authenticated.POST("/me/2fa/enable", handlers.EnableTwoFactor)
+		authenticated.POST("/me/2fa/disable", handlers.DisableTwoFactor)
+		authenticated.POST("/me/2fa/recovery-codes", handlers.RegenerateRecoveryCodes)
+
+		// Passkey routes
+		authenticated.GET("/me/passkeys", handlers.ListPasskeys)
+		authenticated.POST("/me/passkeys/register/begin", handlers.BeginPasskeyRegistration)
+		authenticated.POST("/me/passkeys/register/finish", handlers.FinishPasskeyRegistration)
+		authenticated.DELETE("/me/passkeys/:id", handlers.DeletePasskey)
+
+		// Ledger and payment routes
+		authenticated.GET("/me/balance", handlers.GetMyBalance)
+		authenticated.GET("/me/statement", handlers.GetMyStatement)
+		authenticated.PUT("/me/payment-method", handlers.SetPaymentMethod)
 
 		// Book routes
-		authenticated.POST("/books", handlers.CreateBook)
-		authenticated.GET("/my-books", handlers.GetMyBooks)         // Existing route
-		authenticated.GET("/books/owned", handlers.GetOwnedBooks)   // New route for owners
-		authenticated.GET("/rented-books", handlers.GetRentedBooks) // New route for seekers
+		authenticated.POST("/books", middleware.Authorize(policy.CreateBook), handlers.CreateBook)
+		authenticated.GET("/my-books", handlers.GetMyBooks)                                                       // Existing route
+		authenticated.GET("/books/owned", middleware.Authorize(policy.ListOwnedBooks), handlers.GetOwnedBooks)    // New route for owners
+		authenticated.GET("/rented-books", middleware.Authorize(policy.ListRentedBooks), handlers.GetRentedBooks) // New route for seekers
 		authenticated.PUT("/books/:id", handlers.UpdateBook)
-		authenticated.POST("/books/:id/request", handlers.RequestBook)
+		authenticated.POST("/books/:id/request", middleware.Authorize(policy.RequestBook), handlers.RequestBook)
 		authenticated.DELETE("/books/:id", handlers.DeleteBook)
 		authenticated.PATCH("/books/:id/status", handlers.UpdateBookStatus)
+		authenticated.GET("/books/:id/requests", handlers.ListBookRequests)
+
+		// Copy routes
+		authenticated.POST("/books/:id/copies", handlers.AddBookCopy)
+		authenticated.PUT("/books/:id/copies/:copyId", handlers.UpdateBookCopy)
+		authenticated.PATCH("/books/:id/copies/:copyId/status", handlers.UpdateCopyStatus)
+		authenticated.DELETE("/books/:id/copies/:copyId", handlers.RemoveBookCopy)
+
+		// Waitlist routes
+		authenticated.GET("/waitlist", handlers.ListMyWaitlists)
+		authenticated.POST("/books/:id/waitlist", middleware.Authorize(policy.JoinWaitlist), handlers.JoinWaitlist)
+		authenticated.DELETE("/books/:id/waitlist", handlers.LeaveWaitlist)
+		authenticated.GET("/books/:id/waitlist/position", handlers.GetWaitlistPosition)
+		authenticated.POST("/books/:id/waitlist/accept", handlers.AcceptWaitlistOffer)
+		authenticated.POST("/books/:id/waitlist/decline", handlers.DeclineWaitlistOffer)
+		authenticated.GET("/books/:id/waitlist", handlers.GetBookWaitlist)
+		authenticated.DELETE("/books/:id/waitlist/:entryId", handlers.RemoveWaitlistEntry)
+
+		// Rental request routes
+		authenticated.GET("/rental-requests", handlers.ListRentalRequests)
+		authenticated.POST("/rental-requests/:id/approve", handlers.ApproveRentalRequest)
+		authenticated.POST("/rental-requests/:id/decline", handlers.DeclineRentalRequest)
+		authenticated.POST("/rental-requests/:id/cancel", handlers.CancelRentalRequest)
+
+		// Rental routes
+		authenticated.GET("/rentals", handlers.ListRentals)
+		authenticated.GET("/rentals/overdue", handlers.ListOverdueRentals)
+		authenticated.GET("/rentals/:id", handlers.GetRental)
+		authenticated.POST("/rentals/:id/extend", handlers.RequestExtension)
+		authenticated.POST("/rentals/:id/extension/approve", handlers.ApproveExtension)
+		authenticated.POST("/rentals/:id/extension/decline", handlers.DeclineExtension)
+		authenticated.POST("/rentals/:id/return", handlers.MarkReturned)
+		authenticated.POST("/rentals/:id/confirm-return", handlers.ConfirmReturn)
 
 		// User profile routes
 		authenticated.GET("/users/:id", handlers.GetUserProfile)
 	}
 
-	// Owner-only routes
-	ownerOnly := router.Group("/api/admin")
-	ownerOnly.Use(middleware.AuthRequired(), middleware.OwnerOnly())
+	// Admin-only routes
+	adminOnly := router.Group("/api/admin")
+	adminOnly.Use(middleware.AuthRequired(), middleware.CSRFProtected(), middleware.Authorize(policy.Administer))
 	{
-		ownerOnly.GET("/users", handlers.ListUsers)
+		adminOnly.GET("/users", handlers.ListUsers)
+		adminOnly.POST("/users/:id/2fa/reset", handlers.ResetTwoFactor)
+		adminOnly.POST("/users/:id/suspend", handlers.SuspendUser)
+		adminOnly.POST("/users/:id/reinstate", handlers.ReinstateUser)
+		adminOnly.GET("/login-attempts", handlers.ListLoginAttempts)
 	}
 }
@@ -2,15 +2,27 @@ package middleware
 
 import (
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
 	"nextchapter.com/m/models"
+	"nextchapter.com/m/policy"
 )
 
+// session is a signed in user. It ends after the configured session lifetime
+// whether it is used with the cookie or as a bearer token.
+type session struct {
+	userID    string
+	startedAt time.Time // when the user signed in
+	expiresAt time.Time
+}
+
 // In-memory session store
 var (
-	sessions    = make(map[string]string) // maps sessionID to userID
+	sessions    = make(map[string]session) // maps sessionID to session
 	sessionLock sync.RWMutex
 )
 
@@ -18,15 +30,36 @@ var (
 func SetSession(sessionID, userID string) {
 	sessionLock.Lock()
 	defer sessionLock.Unlock()
-	sessions[sessionID] = userID
+	now := time.Now()
+	for id, s := range sessions {
+		if now.After(s.expiresAt) {
+			delete(sessions, id)
+			removeCSRFToken(id)
+		}
+	}
+	sessions[sessionID] = session{userID: userID, startedAt: now, expiresAt: now.Add(config.Get().SessionLifetime)}
+}
+
+// SessionStartedAt returns when the user signed in to a session
+func SessionStartedAt(sessionID string) (time.Time, bool) {
+	sessionLock.RLock()
+	defer sessionLock.RUnlock()
+	s, exists := sessions[sessionID]
+	if !exists || time.Now().After(s.expiresAt) {
+		return time.Time{}, false
+	}
+	return s.startedAt, true
 }
 
 // GetSession retrieves a user ID from a session ID
 func GetSession(sessionID string) (string, bool) {
 	sessionLock.RLock()
 	defer sessionLock.RUnlock()
-	userID, exists := sessions[sessionID]
-	return userID, exists
+	s, exists := sessions[sessionID]
+	if !exists || time.Now().After(s.expiresAt) {
+		return "", false
+	}
+	return s.userID, true
 }
 
 // RemoveSession removes a session from the store
@@ -34,43 +67,118 @@ func RemoveSession(sessionID string) {
 	sessionLock.Lock()
 	defer sessionLock.Unlock()
 	delete(sessions, sessionID)
+	removeCSRFToken(sessionID)
 }
 
-// AuthRequired is a middleware that checks if the user is authenticated
-func AuthRequired() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get session from cookie
-		sessionID, err := c.Cookie("session")
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
-			c.Abort()
-			return
+// RemoveUserSessions signs a user out everywhere except the session to keep,
+// which may be empty to end every session
+func RemoveUserSessions(userID, keepSessionID string) {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	for sessionID, s := range sessions {
+		if s.userID == userID && sessionID != keepSessionID {
+			delete(sessions, sessionID)
+			removeCSRFToken(sessionID)
 		}
+	}
+}
 
-		// Check if session exists
-		userID, exists := GetSession(sessionID)
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
-			c.Abort()
-			return
-		}
+// Ways a request can carry its session
+const (
+	AuthMethodCookie = "cookie"
+	AuthMethodBearer = "bearer"
+)
 
-		// Get user from session
-		user, found := models.GetUserByID(userID)
-		if !found {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+// AuthRequired is a middleware that checks if the user is authenticated.
+// Browsers send the session in the session cookie; other clients can send the
+// same signed cookie value as a bearer token in the Authorization header.
+func AuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if status, message := authenticate(c); status != 0 {
+			c.JSON(status, gin.H{"error": message})
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Set user in context
-		c.Set("user", user)
+// OptionalAuth is a middleware for public routes whose responses depend on who
+// is asking. A valid session sets the user as AuthRequired does; requests
+// without one carry on anonymously.
+func OptionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authenticate(c)
 		c.Next()
 	}
 }
 
-// OwnerOnly is a middleware that ensures only book owners can access a route
-func OwnerOnly() gin.HandlerFunc {
+// authenticate resolves the request's session and sets the user, session ID
+// and auth method in the context. On failure it returns the status and message
+// to respond with.
+func authenticate(c *gin.Context) (int, string) {
+	cookieName := config.Get().SessionCookieName
+	authMethod := AuthMethodCookie
+	signed, err := c.Cookie(cookieName)
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		authMethod = AuthMethodBearer
+		signed, err = strings.TrimPrefix(header, "Bearer "), nil
+	}
+	if err != nil || signed == "" {
+		return http.StatusUnauthorized, "Not authenticated"
+	}
+
+	sessionID, valid, stale := VerifyCookieValue(cookieName, signed)
+	if !valid {
+		return http.StatusUnauthorized, "Invalid session"
+	}
+
+	// Check if session exists
+	userID, exists := GetSession(sessionID)
+	if !exists {
+		return http.StatusUnauthorized, "Invalid session"
+	}
+
+	// Get user from session
+	user, found := models.GetUserByID(userID)
+	if !found {
+		return http.StatusUnauthorized, "User not found"
+	}
+
+	// Sessions are ended when an account is suspended, this catches
+	// bearer tokens and anything created since
+	if user.IsSuspended(time.Now()) {
+		return http.StatusForbidden, SuspensionMessage(user)
+	}
+
+	// Re-sign cookies made with a key that is being rotated out
+	if stale && authMethod == AuthMethodCookie {
+		SetSessionCookie(c, sessionID)
+	}
+
+	// Set user and session in context
+	c.Set("user", user)
+	c.Set("sessionID", sessionID)
+	c.Set("authMethod", authMethod)
+	return 0, ""
+}
+
+// SuspensionMessage explains to a suspended user why they can't sign in
+func SuspensionMessage(user models.User) string {
+	message := "Your account has been suspended"
+	if user.Suspension == nil {
+		return message
+	}
+	if user.Suspension.Until != nil {
+		message += " until " + user.Suspension.Until.UTC().Format("2 January 2006 15:04 MST")
+	}
+	return message + ". Reason: " + user.Suspension.Reason
+}
+
+// Authorize is a middleware that lets the request through only if the policy
+// allows the current user to perform the action. Checks that depend on a
+// specific resource are left to the handler.
+func Authorize(action policy.Action) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, exists := c.Get("user")
 		if !exists {
@@ -79,9 +187,8 @@ func OwnerOnly() gin.HandlerFunc {
 			return
 		}
 
-		userData := user.(models.User)
-		if userData.Role != models.RoleOwner {
-			c.JSON(http.StatusForbidden, gin.H{"error": "This action requires owner privileges"})
+		if err := policy.Check(user.(models.User), action, nil); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
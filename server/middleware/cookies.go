@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+)
+
+var (
+	ephemeralKey     config.SigningKey
+	ephemeralKeyOnce sync.Once
+)
+
+// signingKeys returns the configured cookie signing keys. Without any, a random
+// key is generated so cookies still can't be forged, but they stop working
+// when the server restarts.
+func signingKeys() []config.SigningKey {
+	if keys := config.Get().CookieSigningKeys; len(keys) > 0 {
+		return keys
+	}
+	ephemeralKeyOnce.Do(func() {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Failed to generate cookie signing key: %v", err)
+		}
+		ephemeralKey = config.SigningKey{ID: "ephemeral", Secret: secret}
+		log.Println("COOKIE_SIGNING_KEYS is not set, using a random key that changes on restart")
+	})
+	return []config.SigningKey{ephemeralKey}
+}
+
+// cookieSignature is the MAC of a cookie's name and value. The name is
+// included so a value signed for one cookie can't be replayed as another.
+func cookieSignature(key config.SigningKey, name, value string) string {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(name + "=" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// KeyedHash is an HMAC of the value with the active signing key, for values
+// the server has to derive the same way every time without storing them.
+// The purpose keeps hashes made for one use from matching another's.
+func KeyedHash(purpose, value string) []byte {
+	mac := hmac.New(sha256.New, signingKeys()[0].Secret)
+	mac.Write([]byte(purpose + ":" + value))
+	return mac.Sum(nil)
+}
+
+// SignCookieValue returns value.keyID.signature, signed with the active key
+func SignCookieValue(name, value string) string {
+	key := signingKeys()[0]
+	return value + "." + key.ID + "." + cookieSignature(key, name, value)
+}
+
+// VerifyCookieValue checks a signed cookie value and returns the original
+// value. It also reports whether the value was signed with an older key and
+// should be reissued.
+func VerifyCookieValue(name, signed string) (string, bool, bool) {
+	sigAt := strings.LastIndexByte(signed, '.')
+	if sigAt < 0 {
+		return "", false, false
+	}
+	idAt := strings.LastIndexByte(signed[:sigAt], '.')
+	if idAt < 0 {
+		return "", false, false
+	}
+	value, keyID, signature := signed[:idAt], signed[idAt+1:sigAt], signed[sigAt+1:]
+
+	for i, key := range signingKeys() {
+		if key.ID != keyID {
+			continue
+		}
+		if !hmac.Equal([]byte(signature), []byte(cookieSignature(key, name, value))) {
+			return "", false, false
+		}
+		return value, true, i > 0
+	}
+	return "", false, false
+}
+
+// SetCookie sets a signed cookie with the configured domain, Secure and
+// SameSite attributes. It is always HttpOnly.
+func SetCookie(c *gin.Context, name, value string, maxAge time.Duration, path string) {
+	cfg := config.Get()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    SignCookieValue(name, value),
+		Path:     path,
+		Domain:   cfg.CookieDomain,
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   cfg.CookieSecure || cfg.CookieSameSite == http.SameSiteNoneMode, // browsers reject SameSite=None without Secure
+		HttpOnly: true,
+		SameSite: cfg.CookieSameSite,
+	})
+}
+
+// ClearCookie tells the browser to delete a cookie set by SetCookie
+func ClearCookie(c *gin.Context, name, path string) {
+	cfg := config.Get()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     path,
+		Domain:   cfg.CookieDomain,
+		MaxAge:   -1,
+		Secure:   cfg.CookieSecure || cfg.CookieSameSite == http.SameSiteNoneMode,
+		HttpOnly: true,
+		SameSite: cfg.CookieSameSite,
+	})
+}
+
+// ReadCookie returns the value of a signed cookie. Missing, unsigned and
+// tampered cookies are all reported as not found.
+func ReadCookie(c *gin.Context, name string) (string, bool) {
+	signed, err := c.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	value, valid, _ := VerifyCookieValue(name, signed)
+	return value, valid
+}
+
+// SetSessionCookie sets the session cookie for a new session
+func SetSessionCookie(c *gin.Context, sessionID string) {
+	SetCookie(c, config.Get().SessionCookieName, sessionID, config.Get().SessionLifetime, "/")
+}
+
+// ClearSessionCookie removes the session cookie
+func ClearSessionCookie(c *gin.Context) {
+	ClearCookie(c, config.Get().SessionCookieName, "/")
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"nextchapter.com/m/config"
+)
+
+// useSigningKeys configures the cookie signing keys for the rest of the test
+func useSigningKeys(t *testing.T, keys ...config.SigningKey) {
+	t.Helper()
+	previous := config.Get()
+	t.Cleanup(func() { config.Set(previous) })
+	cfg := config.Get()
+	cfg.CookieSigningKeys = keys
+	config.Set(cfg)
+}
+
+var (
+	oldKey = config.SigningKey{ID: "old", Secret: []byte("old secret")}
+	newKey = config.SigningKey{ID: "new", Secret: []byte("new secret")}
+)
+
+func TestVerifyCookieValueAcrossKeyRotation(t *testing.T) {
+	useSigningKeys(t, oldKey)
+	signed := SignCookieValue("session", "abc.def")
+
+	if value, valid, stale := VerifyCookieValue("session", signed); !valid || stale || value != "abc.def" {
+		t.Fatalf("verified as %q, valid %v, stale %v", value, valid, stale)
+	}
+
+	// The new key signs from now on, the old one is still accepted
+	useSigningKeys(t, newKey, oldKey)
+	if value, valid, stale := VerifyCookieValue("session", signed); !valid || !stale || value != "abc.def" {
+		t.Errorf("old cookie verified as %q, valid %v, stale %v", value, valid, stale)
+	}
+	if _, valid, stale := VerifyCookieValue("session", SignCookieValue("session", "abc.def")); !valid || stale {
+		t.Errorf("new cookie valid %v, stale %v", valid, stale)
+	}
+
+	// Once the old key is dropped its cookies are refused
+	useSigningKeys(t, newKey)
+	if _, valid, _ := VerifyCookieValue("session", signed); valid {
+		t.Error("cookie signed with a dropped key was accepted")
+	}
+}
+
+func TestVerifyCookieValueRejectsTampering(t *testing.T) {
+	useSigningKeys(t, newKey)
+	signed := SignCookieValue("session", "abc")
+
+	for name, value := range map[string]string{
+		"changed value":  "abd" + signed[3:],
+		"unknown key":    "abc.other." + signed[len("abc.new."):],
+		"unsigned value": "abc",
+		"empty":          "",
+	} {
+		if _, valid, _ := VerifyCookieValue("session", value); valid {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+	if _, valid, _ := VerifyCookieValue("oidc_state", signed); valid {
+		t.Error("a session cookie was accepted as another cookie")
+	}
+}
+
+func TestStaleSessionCookieIsResigned(t *testing.T) {
+	router, sessionID := newSessionTest(t)
+	useSigningKeys(t, oldKey)
+	signed := SignCookieValue(config.Get().SessionCookieName, sessionID)
+	useSigningKeys(t, newKey, oldKey)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/thing", nil)
+	req.AddCookie(&http.Cookie{Name: config.Get().SessionCookieName, Value: signed})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("request with an old key's cookie answered %d", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("set %d cookies, want the re-signed session", len(cookies))
+	}
+	if _, valid, stale := VerifyCookieValue(cookies[0].Name, cookies[0].Value); !valid || stale {
+		t.Errorf("re-signed cookie valid %v, stale %v", valid, stale)
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/models"
+)
+
+// newSessionTest saves a user signed in to a new session and returns a
+// router serving GET and POST /api/thing behind AuthRequired and
+// CSRFProtected, with the session ID
+func newSessionTest(t *testing.T) (*gin.Engine, string) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+
+	user := models.User{ID: "csrf-user", Name: "Reader", Email: "csrf@example.com", Roles: []string{models.RoleSeeker}}
+	if err := models.SaveUser(user); err != nil {
+		t.Fatal(err)
+	}
+	sessionID := "csrf-session"
+	SetSession(sessionID, user.ID)
+	t.Cleanup(func() { RemoveSession(sessionID) })
+
+	router := gin.New()
+	protected := router.Group("/api", AuthRequired(), CSRFProtected())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	protected.GET("/thing", ok)
+	protected.POST("/thing", ok)
+	return router, sessionID
+}
+
+func sessionRequest(router *gin.Engine, method, sessionID, token string, bearer bool) int {
+	req := httptest.NewRequest(method, "/api/thing", nil)
+	signed := SignCookieValue(config.Get().SessionCookieName, sessionID)
+	if bearer {
+		req.Header.Set("Authorization", "Bearer "+signed)
+	} else {
+		req.AddCookie(&http.Cookie{Name: config.Get().SessionCookieName, Value: signed})
+	}
+	if token != "" {
+		req.Header.Set(CSRFHeader, token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestCSRFProtected(t *testing.T) {
+	router, sessionID := newSessionTest(t)
+	token, err := CSRFToken(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		token  string
+		bearer bool
+		want   int
+	}{
+		{"cookie without token", http.MethodPost, "", false, http.StatusForbidden},
+		{"cookie with wrong token", http.MethodPost, "not-the-token", false, http.StatusForbidden},
+		{"cookie with token", http.MethodPost, token, false, http.StatusOK},
+		{"safe method without token", http.MethodGet, "", false, http.StatusOK},
+		{"bearer without token", http.MethodPost, "", true, http.StatusOK},
+	}
+	for _, tc := range cases {
+		if code := sessionRequest(router, tc.method, sessionID, tc.token, tc.bearer); code != tc.want {
+			t.Errorf("%s answered %d, want %d", tc.name, code, tc.want)
+		}
+	}
+}
+
+func TestCSRFTokenEndsWithSession(t *testing.T) {
+	_, sessionID := newSessionTest(t)
+	token, err := CSRFToken(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := CSRFToken(sessionID); again != token {
+		t.Error("the session's token changed")
+	}
+	RemoveSession(sessionID)
+	if validCSRFToken(sessionID, token) {
+		t.Error("token still valid after the session ended")
+	}
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginThrottle tracks failed logins per key and locks the key out with an
+// exponentially growing delay once the free attempts are used up
+type LoginThrottle struct {
+	FreeAttempts int           // failures allowed before a lockout starts
+	BaseDelay    time.Duration // lockout after the first failure past FreeAttempts
+	MaxDelay     time.Duration // upper bound for a single lockout
+	ResetAfter   time.Duration // failures are forgotten after this long without a new one
+
+	mu      sync.Mutex
+	entries map[string]*throttleEntry
+}
+
+type throttleEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Retry returns how long the key is locked out for, or zero if it may try now
+func (t *LoginThrottle) Retry(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry := t.entry(key, now)
+	if entry == nil || !now.Before(entry.lockedUntil) {
+		return 0
+	}
+	return entry.lockedUntil.Sub(now)
+}
+
+// Failure records a failed attempt for the key
+func (t *LoginThrottle) Failure(key string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry := t.entry(key, now)
+	if entry == nil {
+		if t.entries == nil {
+			t.entries = make(map[string]*throttleEntry)
+		}
+		entry = &throttleEntry{}
+		t.entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+
+	if over := entry.failures - t.FreeAttempts; over > 0 {
+		delay := t.BaseDelay
+		for i := 1; i < over && delay < t.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > t.MaxDelay {
+			delay = t.MaxDelay
+		}
+		entry.lockedUntil = now.Add(delay)
+	}
+}
+
+// Reset forgets all failures for the key
+func (t *LoginThrottle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+// entry returns the live entry for a key, dropping it if it has gone stale.
+// The caller must hold t.mu.
+func (t *LoginThrottle) entry(key string, now time.Time) *throttleEntry {
+	entry, exists := t.entries[key]
+	if !exists {
+		return nil
+	}
+	if now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > t.ResetAfter {
+		delete(t.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// Login throttles keyed by account email and by client IP. The IP limit is
+// looser because several people can share an address.
+var (
+	emailThrottle = &LoginThrottle{FreeAttempts: 5, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute, ResetAfter: time.Hour}
+	ipThrottle    = &LoginThrottle{FreeAttempts: 20, BaseDelay: 30 * time.Second, MaxDelay: time.Hour, ResetAfter: time.Hour}
+)
+
+// LoginRetryAfter reports how long a login for this IP and email must wait,
+// or zero if the attempt may go ahead
+func LoginRetryAfter(ip, email string) time.Duration {
+	now := time.Now()
+	wait := ipThrottle.Retry(ip, now)
+	if emailWait := emailThrottle.Retry(normalizeEmail(email), now); emailWait > wait {
+		wait = emailWait
+	}
+	return wait
+}
+
+// RecordLoginFailure counts a failed login against the IP and the email
+func RecordLoginFailure(ip, email string) {
+	now := time.Now()
+	ipThrottle.Failure(ip, now)
+	emailThrottle.Failure(normalizeEmail(email), now)
+}
+
+// RecordLoginSuccess clears the failures for the email. The IP keeps its count
+// so that one valid account cannot be used to unlock guessing at others.
+func RecordLoginSuccess(email string) {
+	emailThrottle.Reset(normalizeEmail(email))
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
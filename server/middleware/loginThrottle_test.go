@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestThrottle() *LoginThrottle {
+	return &LoginThrottle{FreeAttempts: 2, BaseDelay: 30 * time.Second, MaxDelay: 2 * time.Minute, ResetAfter: time.Hour}
+}
+
+func TestLoginThrottleLocksOutAfterFreeAttempts(t *testing.T) {
+	throttle := newTestThrottle()
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 2; i++ {
+		throttle.Failure("a@example.com", now)
+		if wait := throttle.Retry("a@example.com", now); wait != 0 {
+			t.Fatalf("locked out for %v after %d failures", wait, i+1)
+		}
+	}
+	throttle.Failure("a@example.com", now)
+	if wait := throttle.Retry("a@example.com", now); wait != 30*time.Second {
+		t.Errorf("first lockout is %v, want 30s", wait)
+	}
+	if wait := throttle.Retry("b@example.com", now); wait != 0 {
+		t.Errorf("another key is locked out for %v", wait)
+	}
+	if wait := throttle.Retry("a@example.com", now.Add(30*time.Second)); wait != 0 {
+		t.Errorf("still locked out for %v once the lockout has passed", wait)
+	}
+}
+
+func TestLoginThrottleBacksOffExponentially(t *testing.T) {
+	throttle := newTestThrottle()
+	now := time.Unix(1700000000, 0)
+	throttle.Failure("key", now)
+	throttle.Failure("key", now)
+
+	for _, want := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 2 * time.Minute} {
+		throttle.Failure("key", now)
+		if wait := throttle.Retry("key", now); wait != want {
+			t.Errorf("lockout is %v, want %v", wait, want)
+		}
+	}
+}
+
+func TestLoginThrottleForgetsAndResets(t *testing.T) {
+	throttle := newTestThrottle()
+	now := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		throttle.Failure("key", now)
+	}
+
+	// Failures are forgotten after ResetAfter without a new one
+	later := now.Add(throttle.ResetAfter + time.Second)
+	throttle.Failure("key", later)
+	if wait := throttle.Retry("key", later); wait != 0 {
+		t.Errorf("old failures still counted, locked out for %v", wait)
+	}
+
+	for i := 0; i < 3; i++ {
+		throttle.Failure("key", later)
+	}
+	throttle.Reset("key")
+	if wait := throttle.Retry("key", later); wait != 0 {
+		t.Errorf("locked out for %v after a reset", wait)
+	}
+}
+
+func TestLoginSuccessKeepsIPCount(t *testing.T) {
+	ip, email := "203.0.113.7", "Throttled@Example.com"
+	t.Cleanup(func() {
+		ipThrottle.Reset(ip)
+		emailThrottle.Reset(normalizeEmail(email))
+	})
+
+	for i := 0; i <= emailThrottle.FreeAttempts; i++ {
+		RecordLoginFailure(ip, email)
+	}
+	if LoginRetryAfter("198.51.100.1", " throttled@example.com") == 0 {
+		t.Error("the email was not locked out from another IP")
+	}
+
+	RecordLoginSuccess(email)
+	if wait := LoginRetryAfter(ip, email); wait != 0 {
+		t.Errorf("locked out for %v after a successful login", wait)
+	}
+	if _, counted := ipThrottle.entries[ip]; !counted {
+		t.Error("a successful login cleared the IP's failures")
+	}
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter allows a fixed number of events per key within a sliding window
+type RateLimiter struct {
+	Limit  int
+	Window time.Duration
+
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+// Allow records an event for the key if it is within the limit. Otherwise it
+// returns how long until the next event would be allowed.
+func (r *RateLimiter) Allow(key string, now time.Time) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.events == nil {
+		r.events = make(map[string][]time.Time)
+	}
+
+	// Drop events that have left the window
+	recent := r.events[key][:0]
+	for _, t := range r.events[key] {
+		if now.Sub(t) < r.Window {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= r.Limit {
+		r.events[key] = recent
+		return recent[0].Add(r.Window).Sub(now), false
+	}
+	r.events[key] = append(recent, now)
+	return 0, true
+}
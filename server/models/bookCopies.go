@@ -0,0 +1,137 @@
+package models
+
+import "strconv"
+
+// Copy conditions, as judged by the owner
+const (
+	CopyNew     = "new"
+	CopyLikeNew = "like-new"
+	CopyGood    = "good"
+	CopyFair    = "fair"
+	CopyWorn    = "worn"
+)
+
+// BookCopy is one physical copy of a title. Copies are requested, lent,
+// returned and withdrawn on their own, so each has its own status and renter.
+// Copy IDs are numbers counting up within the book.
+type BookCopy struct {
+	ID        string `json:"id"`
+	Condition string `json:"condition,omitempty" validate:"omitempty,oneof=new like-new good fair worn"`
+	Status    string `json:"status"`
+	RenterID  string `json:"renterId,omitempty"`
+}
+
+// Availability counts a title's copies
+type Availability struct {
+	Total     int `json:"total"`
+	Available int `json:"available"` // can be requested straight away
+	OnLoan    int `json:"onLoan"`    // checked out, or back with the owner to confirm
+}
+
+// statusPreference orders the statuses from most to least available. A book's
+// status is the first of them any of its copies has.
+var statusPreference = []string{
+	BookAvailable,
+	BookRequested,
+	BookOnHold,
+	BookReturnPending,
+	BookCheckedOut,
+	BookUnavailable,
+	BookArchived,
+}
+
+// copiesStatus summarizes the copies' statuses as the book's status
+func copiesStatus(copies []BookCopy) string {
+	for _, status := range statusPreference {
+		for _, c := range copies {
+			if c.Status == status {
+				return status
+			}
+		}
+	}
+	return BookUnavailable
+}
+
+// Copy finds one of the book's copies, or returns nil
+func (b *Book) Copy(id string) *BookCopy {
+	for i := range b.Copies {
+		if b.Copies[i].ID == id {
+			return &b.Copies[i]
+		}
+	}
+	return nil
+}
+
+// AddCopy adds a copy to the book, numbered after the highest existing copy
+func (b *Book) AddCopy(condition, status string) BookCopy {
+	next := 1
+	for _, c := range b.Copies {
+		if n, err := strconv.Atoi(c.ID); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	c := BookCopy{ID: strconv.Itoa(next), Condition: condition, Status: status}
+	b.Copies = append(b.Copies, c)
+	b.Status = copiesStatus(b.Copies)
+	return c
+}
+
+// SetCopyStatus changes a copy's status, keeping the book's status in step.
+// It returns false if the book has no such copy.
+func (b *Book) SetCopyStatus(id, status string) bool {
+	c := b.Copy(id)
+	if c == nil {
+		return false
+	}
+	c.Status = status
+	b.Status = copiesStatus(b.Copies)
+	return true
+}
+
+// RemoveCopy takes a copy off the book
+func (b *Book) RemoveCopy(id string) {
+	for i, c := range b.Copies {
+		if c.ID == id {
+			b.Copies = append(b.Copies[:i], b.Copies[i+1:]...)
+			break
+		}
+	}
+	b.Status = copiesStatus(b.Copies)
+}
+
+// RequestableCopy picks the copy a new request is for: an available copy if
+// there is one, otherwise one that others have already requested
+func (b Book) RequestableCopy() (BookCopy, bool) {
+	for _, status := range []string{BookAvailable, BookRequested} {
+		for _, c := range b.Copies {
+			if c.Status == status {
+				return c, true
+			}
+		}
+	}
+	return BookCopy{}, false
+}
+
+// RentedBy reports whether the user has one of the book's copies out
+func (b Book) RentedBy(userID string) bool {
+	for _, c := range b.Copies {
+		if c.RenterID != "" && c.RenterID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Availability counts the book's copies
+func (b Book) Availability() Availability {
+	a := Availability{Total: len(b.Copies)}
+	for _, c := range b.Copies {
+		switch c.Status {
+		case BookAvailable:
+			a.Available++
+		case BookCheckedOut, BookReturnPending:
+			a.OnLoan++
+		}
+	}
+	return a
+}
@@ -0,0 +1,83 @@
+package models
+
+// Book statuses. Each copy of a book moves through them as it is requested,
+// lent and returned:
+//
+//	available → requested → checked-out → return-pending → available
+//
+// with a stop at on-hold, instead of available, while the copy is offered to
+// the next seeker on its waitlist. Owners can take a copy off the market as
+// unavailable or archived. The book's own status summarizes its copies.
+const (
+	BookAvailable     = "available"      // can be requested
+	BookRequested     = "requested"      // has pending rental requests
+	BookCheckedOut    = "checked-out"    // lent to a seeker
+	BookReturnPending = "return-pending" // the seeker says it is back, awaiting the owner
+	BookOnHold        = "on-hold"        // offered to the first seeker on the waitlist
+	BookUnavailable   = "unavailable"    // temporarily withdrawn by the owner
+	BookArchived      = "archived"       // withdrawn by the owner and hidden from listings
+)
+
+// bookTransitions lists every allowed status change, and whether the owner
+// may make it directly. The others only happen through the rental workflow.
+var bookTransitions = map[string]map[string]bool{
+	BookAvailable: {
+		BookRequested:   false,
+		BookOnHold:      false, // the copy came free while seekers were waiting
+		BookUnavailable: true,
+		BookArchived:    true,
+	},
+	BookRequested: {
+		BookAvailable:  false, // the last pending request was declined or cancelled
+		BookOnHold:     false, // ... and someone is on the waitlist
+		BookCheckedOut: false,
+	},
+	BookCheckedOut: {
+		BookReturnPending: false,
+	},
+	BookReturnPending: {
+		BookAvailable: false,
+		BookOnHold:    false,
+	},
+	BookOnHold: {
+		BookRequested: false, // the offer was accepted
+		BookAvailable: false, // nobody left on the waitlist took it
+	},
+	BookUnavailable: {
+		BookAvailable: true,
+		BookArchived:  true,
+	},
+	BookArchived: {
+		BookAvailable:   true,
+		BookUnavailable: true,
+	},
+}
+
+// CanTransitionBook reports whether a book may move from one status to another
+func CanTransitionBook(from, to string) bool {
+	_, allowed := bookTransitions[from][to]
+	return allowed
+}
+
+// OwnerCanSetBookStatus reports whether an owner may move their book from one
+// status to another themselves, rather than through the rental workflow
+func OwnerCanSetBookStatus(from, to string) bool {
+	return bookTransitions[from][to]
+}
+
+// IsWaitlisted reports whether seekers join the waitlist for a book with the
+// status instead of requesting it: while it is on loan or held for someone
+// further up the waitlist
+func IsWaitlisted(status string) bool {
+	return status == BookCheckedOut || status == BookReturnPending || status == BookOnHold
+}
+
+// IsRequestable reports whether seekers may request a book with the status
+func IsRequestable(status string) bool {
+	return status == BookAvailable || status == BookRequested
+}
+
+// IsListed reports whether a book with the status appears in public listings
+func IsListed(status string) bool {
+	return status != BookArchived
+}
@@ -4,21 +4,41 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"slices"
 	"sync"
 )
 
-// Book represents a book listing
+// Book represents the listing of a title, with the physical copies the owner
+// has of it. The validate tags are checked when an owner creates or edits a
+// listing.
 type Book struct {
 	ID          string `json:"id"`
-	Title       string `json:"title"`
-	Author      string `json:"author"`
-	Genre       string `json:"genre"`
-	Location    string `json:"location"`
-	ContactInfo string `json:"contactInfo"`
+	Title       string `json:"title" validate:"required,max=200"`
+	Author      string `json:"author" validate:"required,max=200"`
+	Genre       string `json:"genre" validate:"max=50"`
+	Location    string `json:"location" validate:"required,max=200"`
+	ContactInfo string `json:"contactInfo" validate:"required,max=200"`
 	OwnerID     string `json:"ownerId"`
-	Status      string `json:"status"`
-	ImageURL    string `json:"imageUrl,omitempty"`
-	RenterID    string `json:"renterId,omitempty"`
+	// Status summarizes the copies: it is the most available status any of
+	// them has, kept up to date whenever the book is saved
+	Status   string     `json:"status" validate:"required,oneof=available requested checked-out return-pending on-hold unavailable archived"`
+	ImageURL string     `json:"imageUrl,omitempty" validate:"omitempty,http_url,max=2048"`
+	Copies   []BookCopy `json:"copies" validate:"max=50,dive"`
+	// LoanPeriodDays is how long each rental lasts, 0 for the site default
+	LoanPeriodDays int `json:"loanPeriodDays,omitempty" validate:"gte=0,lte=365"`
+	// Pricing is what renting the book costs, nil if it is lent for free
+	Pricing *Pricing `json:"pricing,omitempty"`
+}
+
+// clone copies the book so changes to its copies and pricing don't reach the
+// store until it is saved
+func (b Book) clone() Book {
+	b.Copies = slices.Clone(b.Copies)
+	if b.Pricing != nil {
+		pricing := *b.Pricing
+		b.Pricing = &pricing
+	}
+	return b
 }
 
 var (
@@ -31,7 +51,8 @@ var (
 func SaveBook(book Book) error {
 	bookMutex.Lock()
 	defer bookMutex.Unlock()
-	books[book.ID] = book
+	book.Status = copiesStatus(book.Copies)
+	books[book.ID] = book.clone()
 	return saveBooksToDisk()
 }
 
@@ -40,7 +61,7 @@ func GetBookByID(id string) (Book, bool) {
 	bookMutex.RLock()
 	defer bookMutex.RUnlock()
 	book, exists := books[id]
-	return book, exists
+	return book.clone(), exists
 }
 
 // GetAllBooks returns all books
@@ -49,7 +70,7 @@ func GetAllBooks() []Book {
 	defer bookMutex.RUnlock()
 	allBooks := make([]Book, 0, len(books))
 	for _, book := range books {
-		allBooks = append(allBooks, book)
+		allBooks = append(allBooks, book.clone())
 	}
 	return allBooks
 }
@@ -61,41 +82,32 @@ func GetBooksByOwner(ownerID string) []Book {
 	ownerBooks := make([]Book, 0)
 	for _, book := range books {
 		if book.OwnerID == ownerID {
-			ownerBooks = append(ownerBooks, book)
+			ownerBooks = append(ownerBooks, book.clone())
 		}
 	}
 	return ownerBooks
 }
 
 // DeleteBook removes a book from the data store
-func DeleteBook(id string, userID string) error {
+func DeleteBook(id string) error {
 	bookMutex.Lock()
 	defer bookMutex.Unlock()
-	book, exists := books[id]
-	if !exists {
+	if _, exists := books[id]; !exists {
 		return errors.New("book not found")
 	}
-	// Ensure the user is the owner of the book
-	if book.OwnerID != userID {
-		return errors.New("unauthorized: you can only delete your own books")
-	}
 	delete(books, id)
 	return saveBooksToDisk()
 }
 
 // UpdateBook updates a book in the data store
-func UpdateBook(book Book, userID string) error {
+func UpdateBook(book Book) error {
 	bookMutex.Lock()
 	defer bookMutex.Unlock()
-	existingBook, exists := books[book.ID]
-	if !exists {
+	if _, exists := books[book.ID]; !exists {
 		return errors.New("book not found")
 	}
-	// Ensure the user is the owner of the book
-	if existingBook.OwnerID != userID {
-		return errors.New("unauthorized: you can only update your own books")
-	}
-	books[book.ID] = book
+	book.Status = copiesStatus(book.Copies)
+	books[book.ID] = book.clone()
 	return saveBooksToDisk()
 }
 
@@ -128,23 +140,34 @@ func loadBooksFromDisk() error {
 		return nil
 	}
 	// Unmarshal the data
-	return json.Unmarshal(data, &books)
-}
-
-func GetBooksByRenter(renterID string) []Book {
-	bookMutex.RLock()
-	defer bookMutex.RUnlock()
+	if err := json.Unmarshal(data, &books); err != nil {
+		return err
+	}
 
-	var rentedBooks []Book
-	for _, book := range books {
-		if book.RenterID == renterID && book.Status == "rented" {
-			rentedBooks = append(rentedBooks, book)
+	// Books used to be a single copy, with its status and renter on the book
+	var legacy map[string]struct {
+		RenterID string `json:"renterId"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	migrated := false
+	for id, book := range books {
+		if len(book.Copies) > 0 {
+			continue
+		}
+		// Books used to be just "rented" while lent out
+		status := book.Status
+		if status == "rented" {
+			status = BookCheckedOut
 		}
+		book.Copies = []BookCopy{{ID: "1", Status: status, RenterID: legacy[id].RenterID}}
+		book.Status = copiesStatus(book.Copies)
+		books[id] = book
+		migrated = true
 	}
-	return rentedBooks
-}
-
-// InitBooks initializes the books data store
-func InitBooks() error {
-	return loadBooksFromDisk()
+	if migrated {
+		return saveBooksToDisk()
+	}
+	return nil
 }
@@ -3,6 +3,7 @@ package models
 import (
 	"log"
 	"os"
+	"time"
 )
 
 // InitializeDataStore sets up the data storage
@@ -25,5 +26,44 @@ func InitializeDataStore() {
 		log.Printf("Error loading books: %v", err)
 	}
 
+	// Load email verification tokens from disk
+	if err := loadVerificationsFromDisk(); err != nil {
+		log.Printf("Error loading email verifications: %v", err)
+	}
+
+	// Load the failed login log from disk
+	if err := loadLoginAttemptsFromDisk(); err != nil {
+		log.Printf("Error loading login attempts: %v", err)
+	}
+
+	// Load registered passkeys from disk
+	if err := loadPasskeysFromDisk(); err != nil {
+		log.Printf("Error loading passkeys: %v", err)
+	}
+
+	// Load rental requests from disk, marking the books they are waiting on
+	if err := loadRentalRequestsFromDisk(); err != nil {
+		log.Printf("Error loading rental requests: %v", err)
+	} else if err := markRequestedBooks(); err != nil {
+		log.Printf("Error marking requested books: %v", err)
+	}
+
+	// Load rentals from disk, recording any rented book that has no rental yet
+	if err := loadRentalsFromDisk(); err != nil {
+		log.Printf("Error loading rentals: %v", err)
+	} else if err := migrateRentedBooks(time.Now()); err != nil {
+		log.Printf("Error migrating rented books: %v", err)
+	}
+
+	// Load waitlists from disk
+	if err := loadWaitlistFromDisk(); err != nil {
+		log.Printf("Error loading waitlists: %v", err)
+	}
+
+	// Load the ledger from disk
+	if err := loadLedgerFromDisk(); err != nil {
+		log.Printf("Error loading the ledger: %v", err)
+	}
+
 	log.Println("Data store initialized successfully")
 }
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ledger transaction types
+const (
+	LedgerRentalFee       = "rental-fee"
+	LedgerExtensionFee    = "extension-fee"
+	LedgerLateFee         = "late-fee"
+	LedgerDepositHeld     = "deposit-held"
+	LedgerDepositReleased = "deposit-released"
+	LedgerReversal        = "reversal" // cancels out a transaction posted in error
+)
+
+// UserAccount is the ledger account holding what a user is owed, or owes
+// when negative
+func UserAccount(userID string) string {
+	return "user:" + userID
+}
+
+// HeldAccount is the ledger account holding a user's deposits while their
+// rentals are out
+func HeldAccount(userID string) string {
+	return "user:" + userID + ":held"
+}
+
+// LedgerEntry moves an amount into an account, or out of it when negative
+type LedgerEntry struct {
+	Account string `json:"account"`
+	Amount  int64  `json:"amount"`
+}
+
+// LedgerTransaction is a set of entries in one currency that sum to zero, so
+// every amount charged to one account is credited to another. IDs are derived
+// from what the transaction is for, so it can't be posted twice.
+type LedgerTransaction struct {
+	ID       string        `json:"id"`
+	Type     string        `json:"type"`
+	Currency string        `json:"currency"`
+	Entries  []LedgerEntry `json:"entries"`
+	RentalID string        `json:"rentalId,omitempty"`
+	Memo     string        `json:"memo"`
+	At       time.Time     `json:"at"`
+}
+
+// StatementLine is one transaction as it affected an account
+type StatementLine struct {
+	TransactionID string    `json:"transactionId"`
+	Type          string    `json:"type"`
+	RentalID      string    `json:"rentalId,omitempty"`
+	Memo          string    `json:"memo"`
+	At            time.Time `json:"at"`
+	Amount        int64     `json:"amount"`
+	Balance       int64     `json:"balance"` // the account's balance after the transaction
+}
+
+var (
+	// ErrUnbalanced is returned when a transaction's entries don't sum to zero
+	ErrUnbalanced = errors.New("ledger transaction does not balance")
+	// ErrAlreadyPosted is returned when a transaction with the same ID exists
+	ErrAlreadyPosted = errors.New("ledger transaction already posted")
+)
+
+var (
+	ledgerFilePath = "data/ledger.json"
+	ledger         = make(map[string]LedgerTransaction) // maps transaction ID to transaction
+	ledgerMutex    sync.RWMutex
+)
+
+// PostTransaction records a balanced transaction. Transactions are never
+// changed once posted; mistakes are corrected by posting another.
+func PostTransaction(t LedgerTransaction) error {
+	if t.ID == "" || t.Currency == "" || len(t.Entries) < 2 {
+		return errors.New("ledger transaction needs an ID, a currency and at least two entries")
+	}
+	var sum int64
+	for _, e := range t.Entries {
+		sum += e.Amount
+	}
+	if sum != 0 {
+		return ErrUnbalanced
+	}
+
+	ledgerMutex.Lock()
+	defer ledgerMutex.Unlock()
+	if _, exists := ledger[t.ID]; exists {
+		return ErrAlreadyPosted
+	}
+	ledger[t.ID] = t
+	return saveLedgerToDisk()
+}
+
+// GetLedgerTransaction looks up a transaction
+func GetLedgerTransaction(id string) (LedgerTransaction, bool) {
+	ledgerMutex.RLock()
+	defer ledgerMutex.RUnlock()
+	t, exists := ledger[id]
+	return t, exists
+}
+
+// GetBalances returns an account's balance in each currency it has used
+func GetBalances(account string) map[string]int64 {
+	ledgerMutex.RLock()
+	defer ledgerMutex.RUnlock()
+	balances := make(map[string]int64)
+	for _, t := range ledger {
+		for _, e := range t.Entries {
+			if e.Account == account {
+				balances[t.Currency] += e.Amount
+			}
+		}
+	}
+	return balances
+}
+
+// GetStatement lists the transactions that touched an account in one
+// currency, newest first, with the balance after each
+func GetStatement(account, currency string) []StatementLine {
+	ledgerMutex.RLock()
+	list := make([]LedgerTransaction, 0)
+	for _, t := range ledger {
+		if t.Currency == currency {
+			list = append(list, t)
+		}
+	}
+	ledgerMutex.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].At.Equal(list[j].At) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].At.Before(list[j].At)
+	})
+
+	lines := make([]StatementLine, 0)
+	var balance int64
+	for _, t := range list {
+		var amount int64
+		touched := false
+		for _, e := range t.Entries {
+			if e.Account == account {
+				amount += e.Amount
+				touched = true
+			}
+		}
+		if !touched {
+			continue
+		}
+		balance += amount
+		lines = append(lines, StatementLine{
+			TransactionID: t.ID,
+			Type:          t.Type,
+			RentalID:      t.RentalID,
+			Memo:          t.Memo,
+			At:            t.At,
+			Amount:        amount,
+			Balance:       balance,
+		})
+	}
+	// Newest first
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+// saveLedgerToDisk saves the ledger map to a JSON file
+func saveLedgerToDisk() error {
+	data, err := json.MarshalIndent(ledger, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ledgerFilePath, data, 0644)
+}
+
+// loadLedgerFromDisk loads ledger transactions from the JSON file
+func loadLedgerFromDisk() error {
+	if _, err := os.Stat(ledgerFilePath); os.IsNotExist(err) {
+		return saveLedgerToDisk()
+	}
+	data, err := os.ReadFile(ledgerFilePath)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, &ledger)
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxLoginAttempts is the number of failed attempts kept for review
+const maxLoginAttempts = 1000
+
+// LoginAttempt records a failed or blocked login
+type LoginAttempt struct {
+	Email  string    `json:"email"`
+	IP     string    `json:"ip"`
+	UserID string    `json:"userId,omitempty"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+var (
+	loginAttemptsFilePath = "data/login_attempts.json"
+	loginAttempts         = make([]LoginAttempt, 0)
+	loginAttemptMutex     sync.RWMutex
+)
+
+// RecordLoginAttempt appends a failed login to the log, dropping the oldest
+// entries once the log is full
+func RecordLoginAttempt(attempt LoginAttempt) error {
+	loginAttemptMutex.Lock()
+	defer loginAttemptMutex.Unlock()
+	loginAttempts = append(loginAttempts, attempt)
+	if len(loginAttempts) > maxLoginAttempts {
+		loginAttempts = append([]LoginAttempt(nil), loginAttempts[len(loginAttempts)-maxLoginAttempts:]...)
+	}
+	return saveLoginAttemptsToDisk()
+}
+
+// GetLoginAttempts returns the recorded failed logins, newest first, optionally
+// filtered by email and IP
+func GetLoginAttempts(email, ip string) []LoginAttempt {
+	loginAttemptMutex.RLock()
+	defer loginAttemptMutex.RUnlock()
+	result := make([]LoginAttempt, 0)
+	for i := len(loginAttempts) - 1; i >= 0; i-- {
+		attempt := loginAttempts[i]
+		if email != "" && attempt.Email != email {
+			continue
+		}
+		if ip != "" && attempt.IP != ip {
+			continue
+		}
+		result = append(result, attempt)
+	}
+	return result
+}
+
+// saveLoginAttemptsToDisk saves the login attempt log to a JSON file
+func saveLoginAttemptsToDisk() error {
+	data, err := json.MarshalIndent(loginAttempts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(loginAttemptsFilePath, data, 0644)
+}
+
+// loadLoginAttemptsFromDisk loads the login attempt log from the JSON file
+func loadLoginAttemptsFromDisk() error {
+	if _, err := os.Stat(loginAttemptsFilePath); os.IsNotExist(err) {
+		return saveLoginAttemptsToDisk()
+	}
+	data, err := os.ReadFile(loginAttemptsFilePath)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, &loginAttempts)
+}
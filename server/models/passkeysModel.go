@@ -0,0 +1,114 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Passkey is a WebAuthn credential registered by a user
+type Passkey struct {
+	ID         string    `json:"id"` // base64url encoded credential ID
+	UserID     string    `json:"userId"`
+	Name       string    `json:"name"`
+	PublicKey  []byte    `json:"publicKey"` // COSE_Key encoded
+	Algorithm  int       `json:"algorithm"`
+	SignCount  uint32    `json:"signCount"`
+	AAGUID     []byte    `json:"aaguid,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt,omitempty"`
+}
+
+var (
+	passkeysFilePath = "data/passkeys.json"
+	passkeys         = make(map[string]Passkey) // maps credential ID to passkey
+	passkeyMutex     sync.RWMutex
+)
+
+// SavePasskey adds or updates a passkey
+func SavePasskey(p Passkey) error {
+	passkeyMutex.Lock()
+	defer passkeyMutex.Unlock()
+	passkeys[p.ID] = p
+	return savePasskeysToDisk()
+}
+
+// GetPasskey returns a passkey by credential ID
+func GetPasskey(id string) (Passkey, bool) {
+	passkeyMutex.RLock()
+	defer passkeyMutex.RUnlock()
+	p, exists := passkeys[id]
+	return p, exists
+}
+
+// GetPasskeysByUser returns a user's passkeys, oldest first
+func GetPasskeysByUser(userID string) []Passkey {
+	passkeyMutex.RLock()
+	defer passkeyMutex.RUnlock()
+	list := make([]Passkey, 0)
+	for _, p := range passkeys {
+		if p.UserID == userID {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// UpdatePasskeySignCount records a successful use of a passkey. The update is
+// refused if the counter has not increased since it was read, so two logins
+// racing with the same assertion cannot both succeed.
+func UpdatePasskeySignCount(id string, previous, count uint32, usedAt time.Time) error {
+	passkeyMutex.Lock()
+	defer passkeyMutex.Unlock()
+	p, exists := passkeys[id]
+	if !exists {
+		return errors.New("passkey not found")
+	}
+	if p.SignCount != previous {
+		return errors.New("passkey was used concurrently")
+	}
+	p.SignCount = count
+	p.LastUsedAt = usedAt
+	passkeys[id] = p
+	return savePasskeysToDisk()
+}
+
+// DeletePasskey removes one of the user's passkeys
+func DeletePasskey(userID, id string) error {
+	passkeyMutex.Lock()
+	defer passkeyMutex.Unlock()
+	p, exists := passkeys[id]
+	if !exists || p.UserID != userID {
+		return errors.New("passkey not found")
+	}
+	delete(passkeys, id)
+	return savePasskeysToDisk()
+}
+
+// savePasskeysToDisk saves the passkeys map to a JSON file
+func savePasskeysToDisk() error {
+	data, err := json.MarshalIndent(passkeys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(passkeysFilePath, data, 0644)
+}
+
+// loadPasskeysFromDisk loads passkeys from the JSON file
+func loadPasskeysFromDisk() error {
+	if _, err := os.Stat(passkeysFilePath); os.IsNotExist(err) {
+		return savePasskeysToDisk()
+	}
+	data, err := os.ReadFile(passkeysFilePath)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, &passkeys)
+}
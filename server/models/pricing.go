@@ -0,0 +1,41 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// Pricing models
+const (
+	PricePerDay  = "per-day"  // Price is charged for every day of the loan
+	PricePerLoan = "per-loan" // Price is charged once, however long the loan
+)
+
+// Pricing is what renting a book costs. Amounts are integers in the minor
+// unit of the currency, such as cents for USD.
+type Pricing struct {
+	Currency string `json:"currency" validate:"required,iso4217"`
+	Model    string `json:"model" validate:"required,oneof=per-day per-loan"`
+	Price    int64  `json:"price" validate:"gte=0,lte=100000000"`
+	// Deposit is held while the book is out and released when it comes back
+	Deposit int64 `json:"deposit" validate:"gte=0,lte=100000000"`
+	// LateFee is charged for every full day the book is overdue
+	LateFee int64 `json:"lateFee" validate:"gte=0,lte=100000000"`
+}
+
+// LoanCharge is the price of lending the book for the given number of days
+func (p Pricing) LoanCharge(days int) int64 {
+	if p.Model == PricePerLoan {
+		return p.Price
+	}
+	return p.Price * int64(days)
+}
+
+// DaysBetween counts the days from one time to another, a part day counting
+// as a whole one
+func DaysBetween(from, to time.Time) int {
+	if !to.After(from) {
+		return 0
+	}
+	return int(math.Ceil(to.Sub(from).Hours() / 24))
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Rental request states
+const (
+	RequestPending   = "pending"
+	RequestApproved  = "approved"
+	RequestDeclined  = "declined"
+	RequestCancelled = "cancelled"
+)
+
+// RentalRequest is a seeker asking an owner to rent one of their books. Each
+// request is for the copy allocated to it when it was made.
+type RentalRequest struct {
+	ID        string     `json:"id"`
+	BookID    string     `json:"bookId"`
+	CopyID    string     `json:"copyId"`
+	OwnerID   string     `json:"ownerId"`
+	SeekerID  string     `json:"seekerId"`
+	Status    string     `json:"status"`
+	Message   string     `json:"message,omitempty"`  // from the seeker
+	Response  string     `json:"response,omitempty"` // from the owner when deciding
+	CreatedAt time.Time  `json:"createdAt"`
+	DecidedAt *time.Time `json:"decidedAt,omitempty"`
+}
+
+// ErrRequestNotPending is returned when deciding on a request that has already been decided
+var ErrRequestNotPending = errors.New("request is no longer pending")
+
+var (
+	rentalRequestsFilePath = "data/rental_requests.json"
+	rentalRequests         = make(map[string]RentalRequest) // maps request ID to request
+	rentalRequestMutex     sync.RWMutex
+)
+
+// SaveRentalRequest adds or updates a rental request
+func SaveRentalRequest(r RentalRequest) error {
+	rentalRequestMutex.Lock()
+	defer rentalRequestMutex.Unlock()
+	rentalRequests[r.ID] = r
+	return saveRentalRequestsToDisk()
+}
+
+// GetRentalRequestByID looks up a rental request
+func GetRentalRequestByID(id string) (RentalRequest, bool) {
+	rentalRequestMutex.RLock()
+	defer rentalRequestMutex.RUnlock()
+	r, exists := rentalRequests[id]
+	return r, exists
+}
+
+// GetRentalRequestsByBook returns the requests for a book, oldest first
+func GetRentalRequestsByBook(bookID string) []RentalRequest {
+	return filterRentalRequests(func(r RentalRequest) bool { return r.BookID == bookID })
+}
+
+// GetRentalRequestsByOwner returns the requests received by an owner, oldest first
+func GetRentalRequestsByOwner(ownerID string) []RentalRequest {
+	return filterRentalRequests(func(r RentalRequest) bool { return r.OwnerID == ownerID })
+}
+
+// GetRentalRequestsBySeeker returns the requests made by a seeker, oldest first
+func GetRentalRequestsBySeeker(seekerID string) []RentalRequest {
+	return filterRentalRequests(func(r RentalRequest) bool { return r.SeekerID == seekerID })
+}
+
+// HasPendingRentalRequest reports whether the seeker is already waiting on the book
+func HasPendingRentalRequest(bookID, seekerID string) bool {
+	return len(filterRentalRequests(func(r RentalRequest) bool {
+		return r.BookID == bookID && r.SeekerID == seekerID && r.Status == RequestPending
+	})) > 0
+}
+
+// HasPendingRequestsForCopy reports whether anyone is still waiting on a copy
+// of the book
+func HasPendingRequestsForCopy(bookID, copyID string) bool {
+	return len(filterRentalRequests(func(r RentalRequest) bool {
+		return r.BookID == bookID && r.CopyID == copyID && r.Status == RequestPending
+	})) > 0
+}
+
+// DecideRentalRequest moves a pending request to approved, declined or
+// cancelled. Approving a request declines every other pending request for the
+// same copy, which are returned so their seekers can be told.
+func DecideRentalRequest(id, status, response string, now time.Time) (RentalRequest, []RentalRequest, error) {
+	rentalRequestMutex.Lock()
+	defer rentalRequestMutex.Unlock()
+
+	r, exists := rentalRequests[id]
+	if !exists {
+		return RentalRequest{}, nil, errors.New("rental request not found")
+	}
+	if r.Status != RequestPending {
+		return RentalRequest{}, nil, ErrRequestNotPending
+	}
+	r.Status = status
+	r.Response = response
+	r.DecidedAt = &now
+	rentalRequests[id] = r
+
+	declined := make([]RentalRequest, 0)
+	if status == RequestApproved {
+		for otherID, other := range rentalRequests {
+			if other.BookID == r.BookID && other.CopyID == r.CopyID && other.Status == RequestPending {
+				other.Status = RequestDeclined
+				other.Response = "The book has been lent to someone else"
+				other.DecidedAt = &now
+				rentalRequests[otherID] = other
+				declined = append(declined, other)
+			}
+		}
+	}
+	return r, declined, saveRentalRequestsToDisk()
+}
+
+// ReopenRentalRequests moves decided requests back to pending, undoing a
+// decision that couldn't be carried out
+func ReopenRentalRequests(ids []string) error {
+	rentalRequestMutex.Lock()
+	defer rentalRequestMutex.Unlock()
+	for _, id := range ids {
+		r, exists := rentalRequests[id]
+		if !exists {
+			return errors.New("rental request not found")
+		}
+		r.Status = RequestPending
+		r.Response = ""
+		r.DecidedAt = nil
+		rentalRequests[id] = r
+	}
+	return saveRentalRequestsToDisk()
+}
+
+// filterRentalRequests returns the requests matching keep, oldest first
+func filterRentalRequests(keep func(RentalRequest) bool) []RentalRequest {
+	rentalRequestMutex.RLock()
+	defer rentalRequestMutex.RUnlock()
+	list := make([]RentalRequest, 0)
+	for _, r := range rentalRequests {
+		if keep(r) {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// markRequestedBooks gives requests made before books had copies the book's
+// only copy, and moves available copies with pending requests, made before
+// books had a requested status, to requested
+func markRequestedBooks() error {
+	bookMutex.Lock()
+	defer bookMutex.Unlock()
+	rentalRequestMutex.Lock()
+	defer rentalRequestMutex.Unlock()
+
+	requestsMigrated, booksMigrated := false, false
+	for id, r := range rentalRequests {
+		book, exists := books[r.BookID]
+		if !exists || len(book.Copies) == 0 {
+			continue
+		}
+		if r.CopyID == "" {
+			r.CopyID = book.Copies[0].ID
+			rentalRequests[id] = r
+			requestsMigrated = true
+		}
+		if c := book.Copy(r.CopyID); c != nil && r.Status == RequestPending && c.Status == BookAvailable {
+			c.Status = BookRequested
+			book.Status = copiesStatus(book.Copies)
+			books[book.ID] = book
+			booksMigrated = true
+		}
+	}
+	if requestsMigrated {
+		if err := saveRentalRequestsToDisk(); err != nil {
+			return err
+		}
+	}
+	if booksMigrated {
+		return saveBooksToDisk()
+	}
+	return nil
+}
+
+// saveRentalRequestsToDisk saves the rental requests map to a JSON file
+func saveRentalRequestsToDisk() error {
+	data, err := json.MarshalIndent(rentalRequests, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(rentalRequestsFilePath, data, 0644)
+}
+
+// loadRentalRequestsFromDisk loads rental requests from the JSON file
+func loadRentalRequestsFromDisk() error {
+	if _, err := os.Stat(rentalRequestsFilePath); os.IsNotExist(err) {
+		return saveRentalRequestsToDisk()
+	}
+	data, err := os.ReadFile(rentalRequestsFilePath)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, &rentalRequests)
+}
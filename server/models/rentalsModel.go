@@ -0,0 +1,328 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Rental outcomes. A rental without an outcome is still active.
+const (
+	RentalReturned    = "returned"     // the book came back to its owner
+	RentalBookRemoved = "book-removed" // the listing was deleted during the rental, which is no longer allowed
+)
+
+// Rental event types, recorded in a rental's history
+const (
+	RentalEventStarted            = "started"
+	RentalEventOverdue            = "overdue"
+	RentalEventReminder           = "reminder-sent"
+	RentalEventExtensionRequested = "extension-requested"
+	RentalEventExtensionApproved  = "extension-approved"
+	RentalEventExtensionDeclined  = "extension-declined"
+	RentalEventReturnMarked       = "return-marked" // the renter says the book is back
+	RentalEventReturned           = "returned"      // the owner confirmed receipt
+	RentalEventRemoved            = "book-removed"
+	RentalEventDepositReleased    = "deposit-released" // the payment provider let the hold go
+)
+
+// RentalEvent is one entry in a rental's history
+type RentalEvent struct {
+	Type    string    `json:"type"`
+	At      time.Time `json:"at"`
+	ActorID string    `json:"actorId,omitempty"` // user who caused the event, empty for the system
+	Note    string    `json:"note,omitempty"`
+}
+
+// Rental is one loan of a copy of a book to a seeker, kept after the book
+// comes back
+type Rental struct {
+	ID         string        `json:"id"`
+	BookID     string        `json:"bookId"`
+	CopyID     string        `json:"copyId"`
+	BookTitle  string        `json:"bookTitle"` // kept so history survives the listing being deleted
+	OwnerID    string        `json:"ownerId"`
+	RenterID   string        `json:"renterId"`
+	RequestID  string        `json:"requestId,omitempty"` // the approved rental request, if any
+	StartedAt  time.Time     `json:"startedAt"`
+	DueAt      *time.Time    `json:"dueAt,omitempty"`
+	ReturnedAt *time.Time    `json:"returnedAt,omitempty"`
+	Outcome    string        `json:"outcome,omitempty"`
+	History    []RentalEvent `json:"history"`
+
+	// Overdue is set by the reminder scheduler once the due date passes
+	Overdue bool `json:"overdue,omitempty"`
+	// DueSoonRemindedAt and OverdueRemindedAt are when the renter was last
+	// reminded, so reminders aren't repeated every time the scheduler runs
+	DueSoonRemindedAt *time.Time `json:"dueSoonRemindedAt,omitempty"`
+	OverdueRemindedAt *time.Time `json:"overdueRemindedAt,omitempty"`
+
+	// Extensions are the renter's requests for a later due date, oldest first
+	Extensions []Extension `json:"extensions,omitempty"`
+
+	// Pricing is the book's pricing when the rental started, nil if it was free
+	Pricing *Pricing `json:"pricing,omitempty"`
+	// DepositPaymentID is the payment provider's authorization of the deposit
+	DepositPaymentID string `json:"depositPaymentId,omitempty"`
+	// LateFeesThrough is when the late fees charged so far run up to
+	LateFeesThrough *time.Time `json:"lateFeesThrough,omitempty"`
+}
+
+// Extension is a renter asking to keep a book longer. Its status is one of
+// the rental request states.
+type Extension struct {
+	ID             string     `json:"id"`
+	PreviousDueAt  time.Time  `json:"previousDueAt"`
+	RequestedDueAt time.Time  `json:"requestedDueAt"`
+	Status         string     `json:"status"`
+	Message        string     `json:"message,omitempty"`  // from the renter
+	Response       string     `json:"response,omitempty"` // from the owner when deciding
+	RequestedAt    time.Time  `json:"requestedAt"`
+	DecidedAt      *time.Time `json:"decidedAt,omitempty"`
+}
+
+// Active reports whether the book is still out on this rental
+func (r Rental) Active() bool {
+	return r.Outcome == ""
+}
+
+// PendingExtension returns the index of the extension awaiting the owner, or
+// -1 if there is none
+func (r Rental) PendingExtension() int {
+	for i, ext := range r.Extensions {
+		if ext.Status == RequestPending {
+			return i
+		}
+	}
+	return -1
+}
+
+// ExtensionsGranted counts the extensions the owner has approved
+func (r Rental) ExtensionsGranted() int {
+	granted := 0
+	for _, ext := range r.Extensions {
+		if ext.Status == RequestApproved {
+			granted++
+		}
+	}
+	return granted
+}
+
+// ErrRentalNotActive is returned when ending a rental that has already ended
+var ErrRentalNotActive = errors.New("rental has already ended")
+
+var (
+	rentalsFilePath = "data/rentals.json"
+	rentals         = make(map[string]Rental) // maps rental ID to rental
+	rentalMutex     sync.RWMutex
+)
+
+// SaveRental adds or updates a rental
+func SaveRental(r Rental) error {
+	rentalMutex.Lock()
+	defer rentalMutex.Unlock()
+	rentals[r.ID] = r
+	return saveRentalsToDisk()
+}
+
+// DeleteRental removes a rental that was recorded by mistake. Rentals that
+// really happened are ended with EndRental instead, keeping their history.
+func DeleteRental(id string) error {
+	rentalMutex.Lock()
+	defer rentalMutex.Unlock()
+	if _, exists := rentals[id]; !exists {
+		return errors.New("rental not found")
+	}
+	delete(rentals, id)
+	return saveRentalsToDisk()
+}
+
+// GetRentalByID looks up a rental
+func GetRentalByID(id string) (Rental, bool) {
+	rentalMutex.RLock()
+	defer rentalMutex.RUnlock()
+	r, exists := rentals[id]
+	return r, exists
+}
+
+// GetActiveRentalsForBook returns the rentals the book's copies are currently
+// out on
+func GetActiveRentalsForBook(bookID string) []Rental {
+	return filterRentals(func(r Rental) bool { return r.BookID == bookID && r.Active() })
+}
+
+// GetRentalByDepositPayment finds the rental whose deposit the payment
+// provider authorized as the given payment
+func GetRentalByDepositPayment(paymentID string) (Rental, bool) {
+	list := filterRentals(func(r Rental) bool { return r.DepositPaymentID != "" && r.DepositPaymentID == paymentID })
+	if len(list) == 0 {
+		return Rental{}, false
+	}
+	return list[0], true
+}
+
+// GetRentalsByRenter returns the rentals of a seeker, newest first
+func GetRentalsByRenter(renterID string) []Rental {
+	return filterRentals(func(r Rental) bool { return r.RenterID == renterID })
+}
+
+// GetRentalsByOwner returns the rentals of an owner's books, newest first
+func GetRentalsByOwner(ownerID string) []Rental {
+	return filterRentals(func(r Rental) bool { return r.OwnerID == ownerID })
+}
+
+// GetActiveRentals returns every rental whose book is still out, newest first
+func GetActiveRentals() []Rental {
+	return filterRentals(func(r Rental) bool { return r.Active() })
+}
+
+// GetEndedRentals returns the rentals whose book has come back or been removed
+func GetEndedRentals() []Rental {
+	return filterRentals(func(r Rental) bool { return !r.Active() })
+}
+
+// HasAcceptedRental reports whether the owner has accepted a rental of one of
+// their books by the renter that is still active
+func HasAcceptedRental(ownerID, renterID string) bool {
+	return len(filterRentals(func(r Rental) bool {
+		return r.OwnerID == ownerID && r.RenterID == renterID && r.Active()
+	})) > 0
+}
+
+// AddRentalEvent records an event in an active rental's history
+func AddRentalEvent(id string, event RentalEvent) (Rental, error) {
+	return UpdateRental(id, func(r *Rental) {
+		r.History = append(r.History, event)
+	})
+}
+
+// UpdateRental applies a change to an active rental and saves it
+func UpdateRental(id string, change func(r *Rental)) (Rental, error) {
+	rentalMutex.Lock()
+	defer rentalMutex.Unlock()
+
+	r, exists := rentals[id]
+	if !exists {
+		return Rental{}, errors.New("rental not found")
+	}
+	if !r.Active() {
+		return Rental{}, ErrRentalNotActive
+	}
+	change(&r)
+	rentals[id] = r
+	return r, saveRentalsToDisk()
+}
+
+// EndRental closes an active rental with the given outcome and records why
+func EndRental(id, outcome string, event RentalEvent) (Rental, error) {
+	rentalMutex.Lock()
+	defer rentalMutex.Unlock()
+
+	r, exists := rentals[id]
+	if !exists {
+		return Rental{}, errors.New("rental not found")
+	}
+	if !r.Active() {
+		return Rental{}, ErrRentalNotActive
+	}
+	r.Outcome = outcome
+	if outcome == RentalReturned {
+		returnedAt := event.At
+		r.ReturnedAt = &returnedAt
+	}
+	r.History = append(r.History, event)
+	rentals[id] = r
+	return r, saveRentalsToDisk()
+}
+
+// filterRentals returns the rentals matching keep, newest first
+func filterRentals(keep func(Rental) bool) []Rental {
+	rentalMutex.RLock()
+	defer rentalMutex.RUnlock()
+	list := make([]Rental, 0)
+	for _, r := range rentals {
+		if keep(r) {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.After(list[j].StartedAt) })
+	return list
+}
+
+// migrateRentedBooks gives rentals recorded before books had copies the
+// book's only copy, and gives copies rented before rentals were recorded a
+// rental, so their renters keep seeing them. The start date is unknown, so
+// the migration time is used.
+func migrateRentedBooks(now time.Time) error {
+	bookMutex.RLock()
+	defer bookMutex.RUnlock()
+	rentalMutex.Lock()
+	defer rentalMutex.Unlock()
+
+	migrated := false
+	active := make(map[string]bool) // keyed by book and copy ID
+	for id, r := range rentals {
+		if book, exists := books[r.BookID]; exists && r.CopyID == "" && len(book.Copies) > 0 {
+			r.CopyID = book.Copies[0].ID
+			rentals[id] = r
+			migrated = true
+		}
+		if r.Active() {
+			active[r.BookID+"/"+r.CopyID] = true
+		}
+	}
+	for _, book := range books {
+		for _, c := range book.Copies {
+			lent := c.Status == BookCheckedOut || c.Status == BookReturnPending
+			if !lent || c.RenterID == "" || active[book.ID+"/"+c.ID] {
+				continue
+			}
+			id := "migrated-" + book.ID
+			if c.ID != "1" {
+				id += "-" + c.ID
+			}
+			rentals[id] = Rental{
+				ID:        id,
+				BookID:    book.ID,
+				CopyID:    c.ID,
+				BookTitle: book.Title,
+				OwnerID:   book.OwnerID,
+				RenterID:  c.RenterID,
+				StartedAt: now,
+				History:   []RentalEvent{{Type: RentalEventStarted, At: now, Note: "Recorded from an existing rental"}},
+			}
+			migrated = true
+		}
+	}
+	if !migrated {
+		return nil
+	}
+	return saveRentalsToDisk()
+}
+
+// saveRentalsToDisk saves the rentals map to a JSON file
+func saveRentalsToDisk() error {
+	data, err := json.MarshalIndent(rentals, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(rentalsFilePath, data, 0644)
+}
+
+// loadRentalsFromDisk loads rentals from the JSON file
+func loadRentalsFromDisk() error {
+	if _, err := os.Stat(rentalsFilePath); os.IsNotExist(err) {
+		return saveRentalsToDisk()
+	}
+	data, err := os.ReadFile(rentalsFilePath)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, &rentals)
+}
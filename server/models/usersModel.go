@@ -4,25 +4,132 @@ import (
 	"encoding/json"
 	"os"
 	"sync"
+	"time"
 )
 
 // main roles required
 const (
 	RoleOwner  = "owner"
 	RoleSeeker = "seeker"
+	RoleAdmin  = "admin" // platform administrators, never assigned at registration
 )
 
-var Users = make(map[string]User)
-
-// we initialise the user structure here
+// we initialise the user structure here. The validate tags are checked on
+// registration and profile updates; passwords and roles have their own checks.
 type User struct {
-	ID           string `json:"id"`
-	Name         string `json:"name"`
-	Email        string `json:"email"`
-	Password     string `json:"password"`
-	MobileNumber string `json:"mobileNumber"`
-	Address      string `json:"address"` // Added address field
-	Role         string `json:"role"`
+	ID           string   `json:"id"`
+	Name         string   `json:"name" validate:"required,max=100"`
+	Email        string   `json:"email" validate:"required,email,max=254"`
+	Password     string   `json:"password"`
+	MobileNumber string   `json:"mobileNumber" validate:"omitempty,e164"`
+	Address      string   `json:"address" validate:"max=300"` // Added address field
+	Roles        []string `json:"roles"`                      // a user can be both an owner and a seeker
+	// EmailVerified is set once the user confirms the address in Email
+	EmailVerified bool `json:"emailVerified"`
+	// PendingEmail holds a new address awaiting confirmation
+	PendingEmail string `json:"pendingEmail,omitempty"`
+	// TOTP two-factor authentication settings
+	TOTPEnabled   bool     `json:"totpEnabled"`
+	TOTPSecret    string   `json:"totpSecret,omitempty"`
+	TOTPLastStep  int64    `json:"totpLastStep,omitempty"`  // last accepted time step, prevents code reuse
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"` // SHA-256 hashes of unused recovery codes
+	// Identities are the external identity provider accounts linked to this user
+	Identities []Identity `json:"identities,omitempty"`
+	// Suspension is set while an admin has suspended the account
+	Suspension *Suspension `json:"suspension,omitempty"`
+	// Privacy controls who can see the user's contact details
+	Privacy Privacy `json:"privacy"`
+	// PaymentCustomerID is the payment provider's customer holding the card
+	// the user's deposits are authorized on
+	PaymentCustomerID string `json:"paymentCustomerId,omitempty"`
+}
+
+// Who can see a contact detail
+const (
+	VisibilityPublic  = "public"  // anyone
+	VisibilityRenters = "renters" // users the owner has accepted a rental with
+	VisibilityPrivate = "private" // only the user
+)
+
+// Privacy holds the visibility of each contact detail. Empty means renters.
+type Privacy struct {
+	Email        string `json:"email,omitempty" validate:"omitempty,oneof=public renters private"`
+	MobileNumber string `json:"mobileNumber,omitempty" validate:"omitempty,oneof=public renters private"`
+	Address      string `json:"address,omitempty" validate:"omitempty,oneof=public renters private"`
+}
+
+// Suspension records why and until when an account is suspended
+type Suspension struct {
+	Reason      string     `json:"reason"`
+	Until       *time.Time `json:"until,omitempty"` // nil means until reinstated
+	SuspendedBy string     `json:"suspendedBy"`
+	SuspendedAt time.Time  `json:"suspendedAt"`
+}
+
+// IsSuspended reports whether the account is suspended at the given time.
+// A suspension with an expiry ends by itself once it passes.
+func (u User) IsSuspended(now time.Time) bool {
+	return u.Suspension != nil && (u.Suspension.Until == nil || now.Before(*u.Suspension.Until))
+}
+
+// Identity links a user to an account at an OpenID Connect provider
+type Identity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+// UnmarshalJSON decodes a user, converting the single "role" field used by
+// older users.json files and clients into the roles list
+func (u *User) UnmarshalJSON(data []byte) error {
+	type plainUser User
+	aux := struct {
+		*plainUser
+		Role string `json:"role"`
+	}{plainUser: (*plainUser)(u)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if len(u.Roles) == 0 && aux.Role != "" {
+		u.Roles = []string{aux.Role}
+	}
+	return nil
+}
+
+// HasRole reports whether the user holds the role
+func (u User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// AddRole grants the user a role if they don't already hold it
+func (u *User) AddRole(role string) {
+	if !u.HasRole(role) {
+		u.Roles = append(u.Roles, role)
+	}
+}
+
+// RemoveRole takes a role away from the user
+func (u *User) RemoveRole(role string) {
+	roles := make([]string, 0, len(u.Roles))
+	for _, r := range u.Roles {
+		if r != role {
+			roles = append(roles, r)
+		}
+	}
+	u.Roles = roles
+}
+
+// WithoutSecrets returns a copy of the user that is safe to send to clients
+func (u User) WithoutSecrets() User {
+	u.Password = ""
+	u.TOTPSecret = ""
+	u.TOTPLastStep = 0
+	u.RecoveryCodes = nil
+	return u
 }
 
 // we will initialize the data dirctory file path here
@@ -63,6 +170,47 @@ func GetUserByEmail(email string) (User, bool) {
 	return User{}, false
 }
 
+// GetUserByIdentity looks up the user linked to an identity provider account
+func GetUserByIdentity(provider, subject string) (User, bool) {
+	userMutex.RLock()
+	defer userMutex.RUnlock()
+
+	for _, user := range users {
+		for _, identity := range user.Identities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return user, true
+			}
+		}
+	}
+	return User{}, false
+}
+
+// GetAllUsers returns all users
+func GetAllUsers() []User {
+	userMutex.RLock()
+	defer userMutex.RUnlock()
+
+	allUsers := make([]User, 0, len(users))
+	for _, user := range users {
+		allUsers = append(allUsers, user)
+	}
+	return allUsers
+}
+
+// GetSuspendedUserIDs returns the IDs of users suspended at the given time
+func GetSuspendedUserIDs(now time.Time) map[string]bool {
+	userMutex.RLock()
+	defer userMutex.RUnlock()
+
+	ids := make(map[string]bool)
+	for id, user := range users {
+		if user.IsSuspended(now) {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
 func saveUsersToDisk() error {
 	data, err := json.MarshalIndent(users, "", "  ")
 	if err != nil {
@@ -89,5 +237,34 @@ func loadUsersFromDisk() error {
 		return nil
 	}
 	// Unmarshal the data
-	return json.Unmarshal(data, &users)
+	if err := json.Unmarshal(data, &users); err != nil {
+		return err
+	}
+	return verifyLegacyUsers(data)
+}
+
+// verifyLegacyUsers marks users saved before email verification existed as
+// verified. They signed up when no check was made, and would otherwise be
+// locked out of listing and requesting books. Users who have the field keep
+// its value.
+func verifyLegacyUsers(data []byte) error {
+	var stored map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	migrated := false
+	for id, fields := range stored {
+		if _, found := fields["emailVerified"]; found {
+			continue
+		}
+		if user, exists := users[id]; exists {
+			user.EmailVerified = true
+			users[id] = user
+			migrated = true
+		}
+	}
+	if !migrated {
+		return nil
+	}
+	return saveUsersToDisk()
 }
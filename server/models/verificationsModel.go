@@ -0,0 +1,87 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// EmailVerificationTTL is how long a verification link stays valid
+const EmailVerificationTTL = 24 * time.Hour
+
+// EmailVerification is a pending confirmation of an email address
+type EmailVerification struct {
+	Token     string    `json:"token"`
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ErrInvalidVerification is returned for a verification token that doesn't
+// exist, was already used or has expired
+var ErrInvalidVerification = errors.New("invalid or expired verification token")
+
+var (
+	verificationsFilePath = "data/verifications.json"
+	verifications         = make(map[string]EmailVerification) // maps token to verification
+	verificationMutex     sync.RWMutex
+)
+
+// SaveEmailVerification stores a verification token, replacing any earlier
+// token issued to the same user
+func SaveEmailVerification(v EmailVerification) error {
+	verificationMutex.Lock()
+	defer verificationMutex.Unlock()
+	for token, existing := range verifications {
+		if existing.UserID == v.UserID {
+			delete(verifications, token)
+		}
+	}
+	verifications[v.Token] = v
+	return saveVerificationsToDisk()
+}
+
+// ConsumeEmailVerification removes a token from the store and returns it.
+// Expired tokens are removed as well but reported as invalid.
+func ConsumeEmailVerification(token string) (EmailVerification, error) {
+	verificationMutex.Lock()
+	defer verificationMutex.Unlock()
+	v, exists := verifications[token]
+	if !exists {
+		return EmailVerification{}, ErrInvalidVerification
+	}
+	delete(verifications, token)
+	if err := saveVerificationsToDisk(); err != nil {
+		return EmailVerification{}, err
+	}
+	if time.Now().After(v.ExpiresAt) {
+		return EmailVerification{}, ErrInvalidVerification
+	}
+	return v, nil
+}
+
+// saveVerificationsToDisk saves the verifications map to a JSON file
+func saveVerificationsToDisk() error {
+	data, err := json.MarshalIndent(verifications, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(verificationsFilePath, data, 0644)
+}
+
+// loadVerificationsFromDisk loads verification tokens from the JSON file
+func loadVerificationsFromDisk() error {
+	if _, err := os.Stat(verificationsFilePath); os.IsNotExist(err) {
+		return saveVerificationsToDisk()
+	}
+	data, err := os.ReadFile(verificationsFilePath)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, &verifications)
+}
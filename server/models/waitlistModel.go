@@ -0,0 +1,190 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Waitlist entry states. Waiting and offered entries are in the queue; the
+// others record how an entry left it.
+const (
+	WaitlistWaiting  = "waiting"
+	WaitlistOffered  = "offered"  // the book is held for this seeker until the offer expires
+	WaitlistAccepted = "accepted" // the seeker took the offer and requested the book
+	WaitlistDeclined = "declined" // the seeker turned the offer down
+	WaitlistExpired  = "expired"  // the offer ran out
+	WaitlistLeft     = "left"     // the seeker left the queue
+	WaitlistRemoved  = "removed"  // the owner removed the seeker, or the book
+)
+
+// WaitlistEntry is a seeker's place in the queue for a book that is on loan
+type WaitlistEntry struct {
+	ID             string     `json:"id"`
+	BookID         string     `json:"bookId"`
+	OwnerID        string     `json:"ownerId"`
+	SeekerID       string     `json:"seekerId"`
+	Status         string     `json:"status"`
+	JoinedAt       time.Time  `json:"joinedAt"`
+	CopyID         string     `json:"copyId,omitempty"` // the copy held for an offer
+	OfferedAt      *time.Time `json:"offeredAt,omitempty"`
+	OfferExpiresAt *time.Time `json:"offerExpiresAt,omitempty"`
+	ClosedAt       *time.Time `json:"closedAt,omitempty"`
+}
+
+// InQueue reports whether the entry still holds a place in the queue
+func (e WaitlistEntry) InQueue() bool {
+	return e.Status == WaitlistWaiting || e.Status == WaitlistOffered
+}
+
+// ErrNotInQueue is returned when changing an entry that has left the queue
+var ErrNotInQueue = errors.New("waitlist entry is no longer in the queue")
+
+var (
+	waitlistFilePath = "data/waitlist.json"
+	waitlist         = make(map[string]WaitlistEntry) // maps entry ID to entry
+	waitlistMutex    sync.RWMutex
+)
+
+// SaveWaitlistEntry adds or updates a waitlist entry
+func SaveWaitlistEntry(e WaitlistEntry) error {
+	waitlistMutex.Lock()
+	defer waitlistMutex.Unlock()
+	waitlist[e.ID] = e
+	return saveWaitlistToDisk()
+}
+
+// GetWaitlistEntryByID looks up a waitlist entry
+func GetWaitlistEntryByID(id string) (WaitlistEntry, bool) {
+	waitlistMutex.RLock()
+	defer waitlistMutex.RUnlock()
+	e, exists := waitlist[id]
+	return e, exists
+}
+
+// GetWaitlist returns the queue for a book, first in line first
+func GetWaitlist(bookID string) []WaitlistEntry {
+	return filterWaitlist(func(e WaitlistEntry) bool { return e.BookID == bookID && e.InQueue() })
+}
+
+// HasWaitingSeekers reports whether anyone in the queue for a book is still
+// waiting to be offered a copy
+func HasWaitingSeekers(bookID string) bool {
+	return len(filterWaitlist(func(e WaitlistEntry) bool { return e.BookID == bookID && e.Status == WaitlistWaiting })) > 0
+}
+
+// GetWaitlistBySeeker returns the queues a seeker is in, oldest first
+func GetWaitlistBySeeker(seekerID string) []WaitlistEntry {
+	return filterWaitlist(func(e WaitlistEntry) bool { return e.SeekerID == seekerID && e.InQueue() })
+}
+
+// GetWaitlistEntry returns the seeker's place in the queue for a book
+func GetWaitlistEntry(bookID, seekerID string) (WaitlistEntry, bool) {
+	list := filterWaitlist(func(e WaitlistEntry) bool {
+		return e.BookID == bookID && e.SeekerID == seekerID && e.InQueue()
+	})
+	if len(list) == 0 {
+		return WaitlistEntry{}, false
+	}
+	return list[0], true
+}
+
+// GetOpenOffers returns every entry that has been offered its book
+func GetOpenOffers() []WaitlistEntry {
+	return filterWaitlist(func(e WaitlistEntry) bool { return e.Status == WaitlistOffered })
+}
+
+// OfferWaitlistEntry holds a copy of the book for a waiting entry until
+// expiresAt
+func OfferWaitlistEntry(id, copyID string, now, expiresAt time.Time) (WaitlistEntry, error) {
+	return updateWaitlistEntry(id, func(e *WaitlistEntry) {
+		e.Status = WaitlistOffered
+		e.CopyID = copyID
+		e.OfferedAt = &now
+		e.OfferExpiresAt = &expiresAt
+	})
+}
+
+// CloseWaitlistEntry takes an entry out of the queue with the given status
+func CloseWaitlistEntry(id, status string, now time.Time) (WaitlistEntry, error) {
+	return updateWaitlistEntry(id, func(e *WaitlistEntry) {
+		e.Status = status
+		e.ClosedAt = &now
+	})
+}
+
+// updateWaitlistEntry applies a change to an entry that is still in the queue
+func updateWaitlistEntry(id string, change func(e *WaitlistEntry)) (WaitlistEntry, error) {
+	waitlistMutex.Lock()
+	defer waitlistMutex.Unlock()
+
+	e, exists := waitlist[id]
+	if !exists {
+		return WaitlistEntry{}, errors.New("waitlist entry not found")
+	}
+	if !e.InQueue() {
+		return WaitlistEntry{}, ErrNotInQueue
+	}
+	change(&e)
+	waitlist[id] = e
+	return e, saveWaitlistToDisk()
+}
+
+// filterWaitlist returns the entries matching keep in the order they joined
+func filterWaitlist(keep func(WaitlistEntry) bool) []WaitlistEntry {
+	waitlistMutex.RLock()
+	defer waitlistMutex.RUnlock()
+	list := make([]WaitlistEntry, 0)
+	for _, e := range waitlist {
+		if keep(e) {
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].JoinedAt.Before(list[j].JoinedAt) })
+	return list
+}
+
+// saveWaitlistToDisk saves the waitlist map to a JSON file
+func saveWaitlistToDisk() error {
+	data, err := json.MarshalIndent(waitlist, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(waitlistFilePath, data, 0644)
+}
+
+// loadWaitlistFromDisk loads waitlist entries from the JSON file
+func loadWaitlistFromDisk() error {
+	if _, err := os.Stat(waitlistFilePath); os.IsNotExist(err) {
+		return saveWaitlistToDisk()
+	}
+	data, err := os.ReadFile(waitlistFilePath)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &waitlist); err != nil {
+		return err
+	}
+
+	// Offers made before books had copies hold the book's only copy
+	bookMutex.RLock()
+	defer bookMutex.RUnlock()
+	migrated := false
+	for id, e := range waitlist {
+		if book, exists := books[e.BookID]; exists && e.Status == WaitlistOffered && e.CopyID == "" && len(book.Copies) > 0 {
+			e.CopyID = book.Copies[0].ID
+			waitlist[id] = e
+			migrated = true
+		}
+	}
+	if migrated {
+		return saveWaitlistToDisk()
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+// Package notify tells users about activity on their rentals. Handlers and
+// the reminder scheduler send through it rather than the mailer, so the way
+// users are reached can change in one place.
+package notify
+
+import (
+	"fmt"
+	"sync"
+
+	"nextchapter.com/m/mailer"
+	"nextchapter.com/m/models"
+)
+
+// Notifier delivers a message to a user
+type Notifier interface {
+	Notify(user models.User, subject, body string) error
+}
+
+// EmailNotifier emails users through the mailer
+type EmailNotifier struct{}
+
+// Notify emails the message, greeting the user by name
+func (EmailNotifier) Notify(user models.User, subject, body string) error {
+	return mailer.Send(user.Email, subject, fmt.Sprintf("Hi %s,\n\n%s", user.Name, body))
+}
+
+var (
+	current      Notifier = EmailNotifier{}
+	notifierLock sync.RWMutex
+)
+
+// SetNotifier replaces the notifier used by User
+func SetNotifier(n Notifier) {
+	notifierLock.Lock()
+	defer notifierLock.Unlock()
+	current = n
+}
+
+// User delivers a message to the user with the given ID
+func User(userID, subject, body string) error {
+	user, found := models.GetUserByID(userID)
+	if !found {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	notifierLock.RLock()
+	defer notifierLock.RUnlock()
+	return current.Notify(user, subject, body)
+}
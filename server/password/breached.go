@@ -0,0 +1,103 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// BreachedList is a text file of SHA-1 password hashes, one uppercase hex
+// hash per line and sorted, optionally followed by ":count" as in the Have I
+// Been Pwned downloads. The file is searched in place with a binary search so
+// it never has to fit in memory, and no network access is needed.
+type BreachedList struct {
+	Path string
+}
+
+// Contains reports whether the password's hash is in the list. A missing file
+// is treated as an empty list.
+func (b *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	file, err := os.Open(b.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// Binary search over the lines starting in [lo, hi). Each probe compares
+	// the line containing the midpoint, so the window always shrinks.
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := lineStart(file, lo, mid)
+		if err != nil {
+			return false, err
+		}
+		hash, next, err := lineAt(file, start)
+		if err != nil {
+			return false, err
+		}
+		switch strings.Compare(hash, target) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = next
+		default:
+			hi = start
+		}
+	}
+	return false, nil
+}
+
+// lineStart returns the offset of the start of the line containing offset,
+// looking no further back than floor
+func lineStart(file *os.File, floor, offset int64) (int64, error) {
+	const chunk = 256
+	end := offset
+	for end > floor {
+		begin := end - chunk
+		if begin < floor {
+			begin = floor
+		}
+		buf := make([]byte, end-begin)
+		if _, err := file.ReadAt(buf, begin); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+			return begin + int64(i) + 1, nil
+		}
+		end = begin
+	}
+	return floor, nil
+}
+
+// lineAt returns the hash on the line starting at offset and the offset of
+// the following line
+func lineAt(file *os.File, offset int64) (string, int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(file, offset, 1<<62))
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	next := offset + int64(len(line))
+	line = strings.TrimSpace(line)
+	if colon := strings.IndexByte(line, ':'); colon >= 0 {
+		line = line[:colon]
+	}
+	return strings.ToUpper(line), next, nil
+}
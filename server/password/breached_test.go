@@ -0,0 +1,96 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// writeBreachedList writes the hashes of the passwords as a sorted list, each
+// line ending with suffix, and returns a BreachedList for it
+func writeBreachedList(t *testing.T, passwords []string, suffix func(i int) string, trailingNewline bool) *BreachedList {
+	t.Helper()
+	hashes := make([]string, len(passwords))
+	for i, p := range passwords {
+		hashes[i] = sha1Hex(p)
+	}
+	sort.Strings(hashes)
+	var b strings.Builder
+	for i, h := range hashes {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(h + suffix(i))
+	}
+	if trailingNewline {
+		b.WriteString("\n")
+	}
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &BreachedList{Path: path}
+}
+
+func TestBreachedListFindsEveryEntry(t *testing.T) {
+	passwords := make([]string, 500)
+	for i := range passwords {
+		passwords[i] = fmt.Sprintf("leaked-%d", i)
+	}
+	formats := map[string]func(i int) string{
+		"bare hashes": func(int) string { return "" },
+		"with counts": func(i int) string { return fmt.Sprintf(":%d", i*37) },
+		"CRLF":        func(int) string { return ":1\r" },
+	}
+	for name, suffix := range formats {
+		for _, trailing := range []bool{true, false} {
+			list := writeBreachedList(t, passwords, suffix, trailing)
+			for _, p := range passwords {
+				if found, err := list.Contains(p); err != nil || !found {
+					t.Fatalf("%s: %q not found (%v)", name, p, err)
+				}
+			}
+			for _, p := range []string{"not-leaked", "leaked-500", ""} {
+				if found, err := list.Contains(p); err != nil || found {
+					t.Errorf("%s: %q reported as breached (%v)", name, p, err)
+				}
+			}
+		}
+	}
+}
+
+func TestBreachedListSingleEntry(t *testing.T) {
+	list := writeBreachedList(t, []string{"hunter2"}, func(int) string { return "" }, false)
+	if found, err := list.Contains("hunter2"); err != nil || !found {
+		t.Errorf("only entry not found (%v)", err)
+	}
+	if found, _ := list.Contains("hunter3"); found {
+		t.Error("password missing from a one-entry list was found")
+	}
+}
+
+func TestBreachedListMissingOrEmptyFile(t *testing.T) {
+	dir := t.TempDir()
+	missing := &BreachedList{Path: filepath.Join(dir, "missing.txt")}
+	if found, err := missing.Contains("password"); err != nil || found {
+		t.Errorf("missing file: found %v, err %v", found, err)
+	}
+
+	empty := filepath.Join(dir, "empty.txt")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := (&BreachedList{Path: empty}).Contains("password"); err != nil || found {
+		t.Errorf("empty file: found %v, err %v", found, err)
+	}
+}
@@ -0,0 +1,109 @@
+// Package password checks new passwords against the configured strength
+// policy and a local list of breached passwords.
+package password
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/validation"
+)
+
+// Policy describes what makes a password acceptable
+type Policy struct {
+	MinLength      int
+	BannedPatterns []*regexp.Regexp
+	// Breached is consulted last; nil disables the breached-password check
+	Breached *BreachedList
+}
+
+// FromConfig builds the policy from the active configuration. Invalid banned
+// patterns are logged and skipped.
+func FromConfig(cfg config.Config) Policy {
+	policy := Policy{MinLength: cfg.PasswordMinLength}
+	for _, pattern := range cfg.PasswordBannedPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("Ignoring invalid banned password pattern %q: %v", pattern, err)
+			continue
+		}
+		policy.BannedPatterns = append(policy.BannedPatterns, re)
+	}
+	if cfg.BreachedPasswordsFile != "" {
+		policy.Breached = &BreachedList{Path: cfg.BreachedPasswordsFile}
+	}
+	return policy
+}
+
+// Check returns the ways the password breaks the policy, reported against the
+// named field. Personal details such as the user's name and email are passed
+// so that passwords built from them can be refused.
+func (p Policy) Check(field, password string, personal ...string) []validation.FieldError {
+	errs := make([]validation.FieldError, 0)
+
+	if password == "" {
+		return append(errs, validation.FieldError{Field: field, Code: "required", Message: "Password is required"})
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		errs = append(errs, validation.FieldError{
+			Field:   field,
+			Code:    "too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength),
+		})
+	}
+	if isSingleCharacter(password) {
+		errs = append(errs, validation.FieldError{Field: field, Code: "banned_pattern", Message: "Password must not repeat a single character"})
+	}
+	for _, re := range p.BannedPatterns {
+		if re.MatchString(password) {
+			errs = append(errs, validation.FieldError{Field: field, Code: "banned_pattern", Message: "Password contains a common word or pattern"})
+			break
+		}
+	}
+	for _, value := range personal {
+		if containsPersonal(password, value) {
+			errs = append(errs, validation.FieldError{Field: field, Code: "personal_info", Message: "Password must not contain your name or email"})
+			break
+		}
+	}
+
+	// Only look up passwords that pass everything else, the list is the slowest check
+	if len(errs) == 0 && p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			log.Printf("Breached password check failed: %v", err)
+		} else if breached {
+			errs = append(errs, validation.FieldError{Field: field, Code: "breached", Message: "This password has appeared in a data breach, please choose another"})
+		}
+	}
+
+	return errs
+}
+
+// Check validates a password against the policy from the active configuration
+func Check(field, password string, personal ...string) []validation.FieldError {
+	return FromConfig(config.Get()).Check(field, password, personal...)
+}
+
+// isSingleCharacter reports whether the password is one character repeated
+func isSingleCharacter(password string) bool {
+	first, _ := utf8.DecodeRuneInString(password)
+	return strings.Trim(password, string(first)) == ""
+}
+
+// containsPersonal reports whether the password contains a personal detail.
+// Emails are reduced to the part before the @ and very short values are ignored.
+func containsPersonal(password, value string) bool {
+	if at := strings.Index(value, "@"); at >= 0 {
+		value = value[:at]
+	}
+	value = strings.ToLower(strings.TrimSpace(value))
+	if len(value) < 4 {
+		return false
+	}
+	return strings.Contains(strings.ToLower(password), value)
+}
@@ -0,0 +1,149 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory provider for development and tests. It authorizes
+// every payment except those of customers it has been told to decline, and
+// numbers payments in order, so the same calls always give the same results.
+// Its webhooks are Events signed with SignWebhook and WebhookSecret; none are
+// accepted until a secret is set.
+type Fake struct {
+	WebhookSecret string
+	// Now returns the current time, used when checking webhook signatures
+	Now func() time.Time
+
+	mu        sync.Mutex
+	payments  map[string]*Payment
+	refs      map[string]string // maps reference to payment ID
+	declining map[string]bool   // customers whose payments are declined
+	next      int
+	customers int
+}
+
+// NewFake returns a fake provider with no payments
+func NewFake() *Fake {
+	return &Fake{
+		Now:       time.Now,
+		payments:  make(map[string]*Payment),
+		refs:      make(map[string]string),
+		declining: make(map[string]bool),
+	}
+}
+
+// Decline makes the fake refuse to authorize the customer's payments
+func (f *Fake) Decline(customer string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.declining[customer] = true
+}
+
+// Payment returns the fake's record of a payment
+func (f *Fake) Payment(id string) (Payment, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, exists := f.payments[id]
+	if !exists {
+		return Payment{}, false
+	}
+	return *p, true
+}
+
+// CreateCustomer numbers customers in order. Any payment method is accepted.
+func (f *Fake) CreateCustomer(ctx context.Context, req CustomerRequest) (string, error) {
+	if req.PaymentMethod == "" {
+		return "", errors.New("a payment method is required")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.customers++
+	return fmt.Sprintf("fake_cus_%d", f.customers), nil
+}
+
+// Authorize holds the amount unless the customer is declined
+func (f *Fake) Authorize(ctx context.Context, req AuthorizeRequest) (Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, exists := f.refs[req.Reference]; exists && req.Reference != "" {
+		return *f.payments[id], nil
+	}
+	if req.Amount <= 0 {
+		return Payment{}, fmt.Errorf("amount must be positive, got %d", req.Amount)
+	}
+	if f.declining[req.Customer] {
+		return Payment{}, ErrDeclined
+	}
+	f.next++
+	p := &Payment{ID: fmt.Sprintf("fake_pay_%d", f.next), Amount: req.Amount, Currency: req.Currency, Status: StatusAuthorized}
+	f.payments[p.ID] = p
+	if req.Reference != "" {
+		f.refs[req.Reference] = p.ID
+	}
+	return *p, nil
+}
+
+// Capture takes up to the authorized amount of a payment that is still held
+func (f *Fake) Capture(ctx context.Context, paymentID string, amount int64) (Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, exists := f.payments[paymentID]
+	if !exists {
+		return Payment{}, ErrNotFound
+	}
+	if p.Status != StatusAuthorized {
+		return Payment{}, fmt.Errorf("payment %s is %s and can't be captured", p.ID, p.Status)
+	}
+	if amount <= 0 || amount > p.Amount {
+		return Payment{}, fmt.Errorf("can't capture %d of %d authorized", amount, p.Amount)
+	}
+	p.Captured = amount
+	p.Status = StatusCaptured
+	return *p, nil
+}
+
+// Refund releases a held payment, or refunds part or all of a captured one.
+// Releasing a payment twice is not an error.
+func (f *Fake) Refund(ctx context.Context, paymentID string, amount int64) (Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, exists := f.payments[paymentID]
+	if !exists {
+		return Payment{}, ErrNotFound
+	}
+	switch p.Status {
+	case StatusAuthorized:
+		p.Status = StatusReleased
+	case StatusReleased:
+		// Already let go
+	case StatusCaptured:
+		if amount <= 0 || amount > p.Captured-p.Refunded {
+			return Payment{}, fmt.Errorf("can't refund %d of %d captured", amount, p.Captured-p.Refunded)
+		}
+		p.Refunded += amount
+		if p.Refunded == p.Captured {
+			p.Status = StatusRefunded
+		}
+	default:
+		return Payment{}, fmt.Errorf("payment %s is %s and can't be refunded", p.ID, p.Status)
+	}
+	return *p, nil
+}
+
+// VerifyWebhook checks the payload was signed with the webhook secret and
+// decodes the Event it holds
+func (f *Fake) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	if err := verifySignature(f.WebhookSecret, payload, signature, f.Now()); err != nil {
+		return Event{}, err
+	}
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return Event{}, fmt.Errorf("malformed webhook: %w", err)
+	}
+	return event, nil
+}
@@ -0,0 +1,278 @@
+// Package paymentstest provides an in-process stand-in for a Stripe-style
+// payment API, for exercising payments.Stripe without a real account.
+package paymentstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"nextchapter.com/m/payments"
+)
+
+// Server is a mock payment API. It holds customers and PaymentIntents in memory, answers
+// with the status codes and error bodies of the real API, replays requests
+// with the same idempotency key and declines the cards of chosen customers.
+type Server struct {
+	*httptest.Server
+	SecretKey     string
+	WebhookSecret string
+
+	mu        sync.Mutex
+	intents   map[string]*intent
+	replays   map[string][]byte // maps idempotency key to the first answer
+	customers map[string]string // maps customer ID to its default payment method
+	declining map[string]bool
+	next      int
+}
+
+type intent struct {
+	ID             string `json:"id"`
+	Object         string `json:"object"`
+	Amount         int64  `json:"amount"`
+	AmountReceived int64  `json:"amount_received"`
+	Currency       string `json:"currency"`
+	Customer       string `json:"customer"`
+	Status         string `json:"status"`
+	LatestCharge   charge `json:"latest_charge"`
+}
+
+type charge struct {
+	ID             string `json:"id"`
+	Object         string `json:"object"`
+	PaymentIntent  string `json:"payment_intent"`
+	AmountRefunded int64  `json:"amount_refunded"`
+}
+
+// NewServer starts a mock API accepting the given secret key. Close it when done.
+func NewServer(secretKey, webhookSecret string) *Server {
+	s := &Server{
+		SecretKey:     secretKey,
+		WebhookSecret: webhookSecret,
+		intents:       make(map[string]*intent),
+		replays:       make(map[string][]byte),
+		customers:     make(map[string]string),
+		declining:     make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/customers", s.createCustomer)
+	mux.HandleFunc("POST /v1/payment_intents", s.createIntent)
+	mux.HandleFunc("GET /v1/payment_intents/{id}", s.getIntent)
+	mux.HandleFunc("POST /v1/payment_intents/{id}/capture", s.captureIntent)
+	mux.HandleFunc("POST /v1/payment_intents/{id}/cancel", s.cancelIntent)
+	mux.HandleFunc("POST /v1/refunds", s.refund)
+	s.Server = httptest.NewServer(s.authenticate(mux))
+	return s
+}
+
+// Provider returns an adapter configured to talk to the server
+func (s *Server) Provider() *payments.Stripe {
+	p := payments.NewStripe(s.URL, s.SecretKey, s.WebhookSecret)
+	p.HTTPClient = s.Client()
+	return p
+}
+
+// Decline makes the server refuse the customer's cards
+func (s *Server) Decline(customer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.declining[customer] = true
+}
+
+// Status returns the status of a PaymentIntent, or "" if there is none
+func (s *Server) Status(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pi, exists := s.intents[id]; exists {
+		return pi.Status
+	}
+	return ""
+}
+
+// Webhook builds a signed event about a PaymentIntent, returning the body and
+// the Stripe-Signature header to send with it
+func (s *Server) Webhook(eventType, intentID string) ([]byte, string) {
+	s.mu.Lock()
+	object := any(map[string]string{"id": intentID, "object": "payment_intent"})
+	if pi, exists := s.intents[intentID]; exists {
+		if eventType == "charge.refunded" {
+			object = pi.LatestCharge
+		} else {
+			object = *pi
+		}
+	}
+	s.next++
+	id := "evt_" + strconv.Itoa(s.next)
+	s.mu.Unlock()
+
+	payload, _ := json.Marshal(map[string]any{
+		"id":     id,
+		"object": "event",
+		"type":   eventType,
+		"data":   map[string]any{"object": object},
+	})
+	return payload, payments.SignWebhook(s.WebhookSecret, payload, time.Now())
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+s.SecretKey {
+			writeError(w, http.StatusUnauthorized, "invalid_request_error", "Invalid API key provided")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) createCustomer(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	method := r.FormValue("payment_method")
+	if method == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Missing required param: payment_method")
+		return
+	}
+	s.next++
+	id := fmt.Sprintf("cus_%d", s.next)
+	s.customers[id] = method
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "object": "customer", "email": r.FormValue("email")})
+}
+
+func (s *Server) createIntent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := r.Header.Get("Idempotency-Key")
+	if answer, exists := s.replays[key]; exists && key != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(answer)
+		return
+	}
+
+	amount, err := strconv.ParseInt(r.FormValue("amount"), 10, 64)
+	if err != nil || amount <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Invalid positive integer: amount")
+		return
+	}
+	if r.FormValue("currency") == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Missing required param: currency")
+		return
+	}
+	customer := r.FormValue("customer")
+	if _, exists := s.customers[customer]; !exists {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "No such customer: '"+customer+"'")
+		return
+	}
+	if s.declining[customer] {
+		writeError(w, http.StatusPaymentRequired, "card_error", "Your card was declined.")
+		return
+	}
+
+	s.next++
+	id := fmt.Sprintf("pi_%d", s.next)
+	pi := &intent{
+		ID:           id,
+		Object:       "payment_intent",
+		Amount:       amount,
+		Currency:     r.FormValue("currency"),
+		Customer:     customer,
+		Status:       "requires_payment_method",
+		LatestCharge: charge{ID: fmt.Sprintf("ch_%d", s.next), Object: "charge", PaymentIntent: id},
+	}
+	if r.FormValue("confirm") == "true" {
+		pi.Status = "succeeded"
+		if r.FormValue("capture_method") == "manual" {
+			pi.Status = "requires_capture"
+		} else {
+			pi.AmountReceived = amount
+		}
+	}
+	s.intents[id] = pi
+	answer, _ := json.Marshal(pi)
+	if key != "" {
+		s.replays[key] = answer
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(answer)
+}
+
+func (s *Server) getIntent(w http.ResponseWriter, r *http.Request) {
+	s.withIntent(w, r.PathValue("id"), func(pi *intent) bool { return true })
+}
+
+func (s *Server) captureIntent(w http.ResponseWriter, r *http.Request) {
+	s.withIntent(w, r.PathValue("id"), func(pi *intent) bool {
+		amount := pi.Amount
+		if value := r.FormValue("amount_to_capture"); value != "" {
+			amount, _ = strconv.ParseInt(value, 10, 64)
+		}
+		if pi.Status != "requires_capture" || amount <= 0 || amount > pi.Amount {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "This PaymentIntent could not be captured")
+			return false
+		}
+		pi.AmountReceived = amount
+		pi.Status = "succeeded"
+		return true
+	})
+}
+
+func (s *Server) cancelIntent(w http.ResponseWriter, r *http.Request) {
+	s.withIntent(w, r.PathValue("id"), func(pi *intent) bool {
+		if pi.Status == "succeeded" || pi.Status == "canceled" {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "This PaymentIntent could not be canceled because it has a status of "+pi.Status)
+			return false
+		}
+		pi.Status = "canceled"
+		return true
+	})
+}
+
+func (s *Server) refund(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pi, exists := s.intents[r.FormValue("payment_intent")]
+	if !exists {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "No such payment_intent")
+		return
+	}
+	amount := pi.AmountReceived - pi.LatestCharge.AmountRefunded
+	if value := r.FormValue("amount"); value != "" {
+		amount, _ = strconv.ParseInt(value, 10, 64)
+	}
+	if pi.Status != "succeeded" || amount <= 0 || amount > pi.AmountReceived-pi.LatestCharge.AmountRefunded {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "This charge can't be refunded")
+		return
+	}
+	pi.LatestCharge.AmountRefunded += amount
+	s.next++
+	writeJSON(w, http.StatusOK, map[string]any{"id": fmt.Sprintf("re_%d", s.next), "object": "refund", "amount": amount, "status": "succeeded"})
+}
+
+// withIntent applies a change to a PaymentIntent and answers with it, unless
+// the change wrote an error and returned false
+func (s *Server) withIntent(w http.ResponseWriter, id string, change func(pi *intent) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pi, exists := s.intents[id]
+	if !exists {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "No such payment_intent: '"+id+"'")
+		return
+	}
+	if change(pi) {
+		writeJSON(w, http.StatusOK, pi)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, kind, message string) {
+	writeJSON(w, status, map[string]any{"error": map[string]string{"type": kind, "message": message}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
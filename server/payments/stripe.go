@@ -0,0 +1,258 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stripe talks to a payment API shaped like Stripe's: form encoded requests
+// authenticated with a secret key, deposits held as PaymentIntents with
+// manual capture, and webhooks signed in the Stripe-Signature header format.
+// Customers are the provider's customer IDs, charged off session with their
+// default payment method.
+type Stripe struct {
+	BaseURL       string // such as https://api.stripe.com
+	SecretKey     string
+	WebhookSecret string
+
+	// HTTPClient sends the API requests. Tests can point it at an in-process
+	// stand-in such as paymentstest.Server.
+	HTTPClient *http.Client
+	// Now returns the current time, used when checking webhook signatures
+	Now func() time.Time
+}
+
+// NewStripe returns an adapter for the API at baseURL
+func NewStripe(baseURL, secretKey, webhookSecret string) *Stripe {
+	return &Stripe{
+		BaseURL:       strings.TrimRight(baseURL, "/"),
+		SecretKey:     secretKey,
+		WebhookSecret: webhookSecret,
+		HTTPClient:    &http.Client{Timeout: 30 * time.Second},
+		Now:           time.Now,
+	}
+}
+
+// paymentIntent is the part of a PaymentIntent the adapter reads
+type paymentIntent struct {
+	ID             string `json:"id"`
+	Amount         int64  `json:"amount"`
+	AmountReceived int64  `json:"amount_received"`
+	Currency       string `json:"currency"`
+	Status         string `json:"status"`
+	LatestCharge   *struct {
+		AmountRefunded int64 `json:"amount_refunded"`
+	} `json:"latest_charge"` // only when expanded
+}
+
+// payment converts the intent to a Payment
+func (pi paymentIntent) payment() Payment {
+	p := Payment{ID: pi.ID, Amount: pi.Amount, Currency: strings.ToUpper(pi.Currency), Captured: pi.AmountReceived}
+	if pi.LatestCharge != nil {
+		p.Refunded = pi.LatestCharge.AmountRefunded
+	}
+	switch pi.Status {
+	case "requires_capture":
+		p.Status = StatusAuthorized
+	case "succeeded":
+		p.Status = StatusCaptured
+		if p.Captured > 0 && p.Refunded >= p.Captured {
+			p.Status = StatusRefunded
+		}
+	case "canceled":
+		p.Status = StatusReleased
+	default:
+		p.Status = pi.Status
+	}
+	return p
+}
+
+// apiError is the error body the API answers failed requests with
+type apiError struct {
+	Error struct {
+		Type        string `json:"type"`
+		Code        string `json:"code"`
+		DeclineCode string `json:"decline_code"`
+		Message     string `json:"message"`
+	} `json:"error"`
+}
+
+// CreateCustomer creates a customer with the payment method attached as its
+// default, so deposits can be authorized off session
+func (s *Stripe) CreateCustomer(ctx context.Context, req CustomerRequest) (string, error) {
+	form := url.Values{
+		"email":          {req.Email},
+		"name":           {req.Name},
+		"payment_method": {req.PaymentMethod},
+		"invoice_settings[default_payment_method]": {req.PaymentMethod},
+	}
+	var customer struct {
+		ID string `json:"id"`
+	}
+	if err := s.call(ctx, http.MethodPost, "/v1/customers", form, "", &customer); err != nil {
+		return "", err
+	}
+	return customer.ID, nil
+}
+
+// Authorize creates a PaymentIntent for the amount with manual capture and
+// confirms it, so the amount is held until it is captured or cancelled. The
+// reference is sent as the idempotency key.
+func (s *Stripe) Authorize(ctx context.Context, req AuthorizeRequest) (Payment, error) {
+	form := url.Values{
+		"amount":              {strconv.FormatInt(req.Amount, 10)},
+		"currency":            {strings.ToLower(req.Currency)},
+		"customer":            {req.Customer},
+		"capture_method":      {"manual"},
+		"confirm":             {"true"},
+		"off_session":         {"true"},
+		"description":         {req.Description},
+		"metadata[reference]": {req.Reference},
+		"expand[]":            {"latest_charge"},
+	}
+	var pi paymentIntent
+	if err := s.call(ctx, http.MethodPost, "/v1/payment_intents", form, req.Reference, &pi); err != nil {
+		return Payment{}, err
+	}
+	if pi.Status != "requires_capture" {
+		return Payment{}, fmt.Errorf("%w: payment is %s", ErrDeclined, pi.Status)
+	}
+	return pi.payment(), nil
+}
+
+// Capture captures the amount of a held PaymentIntent
+func (s *Stripe) Capture(ctx context.Context, paymentID string, amount int64) (Payment, error) {
+	form := url.Values{"amount_to_capture": {strconv.FormatInt(amount, 10)}, "expand[]": {"latest_charge"}}
+	var pi paymentIntent
+	if err := s.call(ctx, http.MethodPost, "/v1/payment_intents/"+url.PathEscape(paymentID)+"/capture", form, "", &pi); err != nil {
+		return Payment{}, err
+	}
+	return pi.payment(), nil
+}
+
+// Refund cancels a PaymentIntent that is still held, or refunds the amount
+// of one that has been captured. A cancelled PaymentIntent is left as it is.
+func (s *Stripe) Refund(ctx context.Context, paymentID string, amount int64) (Payment, error) {
+	path := "/v1/payment_intents/" + url.PathEscape(paymentID)
+	var pi paymentIntent
+	if err := s.call(ctx, http.MethodGet, path, url.Values{"expand[]": {"latest_charge"}}, "", &pi); err != nil {
+		return Payment{}, err
+	}
+	if pi.Status == "canceled" {
+		return pi.payment(), nil
+	}
+	if pi.Status == "requires_capture" {
+		if err := s.call(ctx, http.MethodPost, path+"/cancel", url.Values{"expand[]": {"latest_charge"}}, "", &pi); err != nil {
+			return Payment{}, err
+		}
+		return pi.payment(), nil
+	}
+
+	form := url.Values{"payment_intent": {paymentID}, "amount": {strconv.FormatInt(amount, 10)}}
+	if err := s.call(ctx, http.MethodPost, "/v1/refunds", form, "", nil); err != nil {
+		return Payment{}, err
+	}
+	if err := s.call(ctx, http.MethodGet, path, url.Values{"expand[]": {"latest_charge"}}, "", &pi); err != nil {
+		return Payment{}, err
+	}
+	return pi.payment(), nil
+}
+
+// VerifyWebhook checks the Stripe-Signature header against the webhook secret
+// and maps the event onto the package's event types. Events about other
+// objects keep the provider's type.
+func (s *Stripe) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	if err := verifySignature(s.WebhookSecret, payload, signature, s.Now()); err != nil {
+		return Event{}, err
+	}
+	var body struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID            string `json:"id"`
+				PaymentIntent string `json:"payment_intent"` // set on charges
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return Event{}, fmt.Errorf("malformed webhook: %w", err)
+	}
+
+	event := Event{ID: body.ID, Type: body.Type, PaymentID: body.Data.Object.ID}
+	switch body.Type {
+	case "payment_intent.amount_capturable_updated":
+		event.Type = EventAuthorized
+	case "payment_intent.succeeded":
+		event.Type = EventCaptured
+	case "payment_intent.canceled":
+		event.Type = EventReleased
+	case "payment_intent.payment_failed":
+		event.Type = EventFailed
+	case "charge.refunded":
+		event.Type = EventRefunded
+		event.PaymentID = body.Data.Object.PaymentIntent
+	}
+	return event, nil
+}
+
+// call sends a request to the API and decodes the JSON answer into out.
+// Card errors are reported as ErrDeclined and missing objects as ErrNotFound.
+func (s *Stripe) call(ctx context.Context, method, path string, form url.Values, idempotencyKey string, out any) error {
+	endpoint := s.BaseURL + path
+	var body io.Reader
+	if method == http.MethodGet {
+		endpoint += "?" + form.Encode()
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.SecretKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr apiError
+		_ = json.Unmarshal(data, &apiErr)
+		switch {
+		case apiErr.Error.Type == "card_error":
+			return fmt.Errorf("%w: %s", ErrDeclined, apiErr.Error.Message)
+		case resp.StatusCode == http.StatusNotFound:
+			return ErrNotFound
+		case apiErr.Error.Message != "":
+			return fmt.Errorf("payment API %s %s: %s (%d)", method, path, apiErr.Error.Message, resp.StatusCode)
+		}
+		return fmt.Errorf("payment API %s %s: status %d", method, path, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("malformed payment API response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package payments_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"nextchapter.com/m/payments"
+	"nextchapter.com/m/payments/paymentstest"
+)
+
+// newStripeTest starts a mock payment API and returns it with an adapter
+// talking to it and a customer with a saved card
+func newStripeTest(t *testing.T) (*paymentstest.Server, *payments.Stripe, string) {
+	t.Helper()
+	server := paymentstest.NewServer("sk_test_key", "whsec_test")
+	t.Cleanup(server.Close)
+	stripe := server.Provider()
+	customer, err := stripe.CreateCustomer(context.Background(), payments.CustomerRequest{Email: "reader@example.com", Name: "Reader", PaymentMethod: "pm_card_visa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, stripe, customer
+}
+
+func authorize(t *testing.T, stripe *payments.Stripe, customer, reference string) payments.Payment {
+	t.Helper()
+	payment, err := stripe.Authorize(context.Background(), payments.AuthorizeRequest{Amount: 1500, Currency: "EUR", Customer: customer, Reference: reference})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payment
+}
+
+func TestStripeAuthorizeHoldsOncePerReference(t *testing.T) {
+	server, stripe, customer := newStripeTest(t)
+
+	payment := authorize(t, stripe, customer, "deposit:rental-1")
+	if payment.Status != payments.StatusAuthorized || payment.Amount != 1500 || payment.Currency != "EUR" {
+		t.Fatalf("authorization returned %+v", payment)
+	}
+	if status := server.Status(payment.ID); status != "requires_capture" {
+		t.Errorf("PaymentIntent is %q, want requires_capture", status)
+	}
+	if again := authorize(t, stripe, customer, "deposit:rental-1"); again.ID != payment.ID {
+		t.Errorf("authorizing the same reference held %s as well as %s", again.ID, payment.ID)
+	}
+}
+
+func TestStripeAuthorizeDeclined(t *testing.T) {
+	server, stripe, customer := newStripeTest(t)
+	server.Decline(customer)
+
+	_, err := stripe.Authorize(context.Background(), payments.AuthorizeRequest{Amount: 1500, Currency: "EUR", Customer: customer, Reference: "deposit:rental-1"})
+	if !errors.Is(err, payments.ErrDeclined) {
+		t.Fatalf("declined card returned %v, want ErrDeclined", err)
+	}
+}
+
+func TestStripeCaptureAndRefund(t *testing.T) {
+	_, stripe, customer := newStripeTest(t)
+	ctx := context.Background()
+	payment := authorize(t, stripe, customer, "deposit:rental-1")
+
+	payment, err := stripe.Capture(ctx, payment.ID, 1000)
+	if err != nil || payment.Status != payments.StatusCaptured || payment.Captured != 1000 {
+		t.Fatalf("capture returned %+v, %v", payment, err)
+	}
+	payment, err = stripe.Refund(ctx, payment.ID, 400)
+	if err != nil || payment.Status != payments.StatusCaptured || payment.Refunded != 400 {
+		t.Fatalf("partial refund returned %+v, %v", payment, err)
+	}
+	payment, err = stripe.Refund(ctx, payment.ID, 600)
+	if err != nil || payment.Status != payments.StatusRefunded || payment.Refunded != 1000 {
+		t.Fatalf("full refund returned %+v, %v", payment, err)
+	}
+}
+
+func TestStripeRefundReleasesHold(t *testing.T) {
+	server, stripe, customer := newStripeTest(t)
+	ctx := context.Background()
+	payment := authorize(t, stripe, customer, "deposit:rental-1")
+
+	released, err := stripe.Refund(ctx, payment.ID, payment.Amount)
+	if err != nil || released.Status != payments.StatusReleased {
+		t.Fatalf("releasing the hold returned %+v, %v", released, err)
+	}
+	if status := server.Status(payment.ID); status != "canceled" {
+		t.Errorf("PaymentIntent is %q, want canceled", status)
+	}
+	// A retried release finds the hold already gone
+	if again, err := stripe.Refund(ctx, payment.ID, payment.Amount); err != nil || again.Status != payments.StatusReleased {
+		t.Errorf("releasing twice returned %+v, %v", again, err)
+	}
+}
+
+func TestStripeErrors(t *testing.T) {
+	server, stripe, _ := newStripeTest(t)
+	ctx := context.Background()
+
+	if _, err := stripe.Refund(ctx, "pi_missing", 100); !errors.Is(err, payments.ErrNotFound) {
+		t.Errorf("unknown payment returned %v, want ErrNotFound", err)
+	}
+	if _, err := stripe.CreateCustomer(ctx, payments.CustomerRequest{Email: "reader@example.com"}); err == nil {
+		t.Error("customer without a payment method was created")
+	}
+
+	wrongKey := payments.NewStripe(server.URL, "sk_wrong", server.WebhookSecret)
+	wrongKey.HTTPClient = server.Client()
+	if _, err := wrongKey.CreateCustomer(ctx, payments.CustomerRequest{PaymentMethod: "pm_card_visa"}); err == nil {
+		t.Error("request with the wrong API key succeeded")
+	}
+}
+
+func TestStripeVerifyWebhook(t *testing.T) {
+	server, stripe, customer := newStripeTest(t)
+	ctx := context.Background()
+	payment := authorize(t, stripe, customer, "deposit:rental-1")
+	if _, err := stripe.Capture(ctx, payment.ID, payment.Amount); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stripe.Refund(ctx, payment.ID, payment.Amount); err != nil {
+		t.Fatal(err)
+	}
+
+	payload, signature := server.Webhook("charge.refunded", payment.ID)
+	event, err := stripe.VerifyWebhook(payload, signature)
+	if err != nil || event.Type != payments.EventRefunded || event.PaymentID != payment.ID {
+		t.Fatalf("webhook verified as %+v, %v", event, err)
+	}
+
+	payload, signature = server.Webhook("payment_intent.canceled", payment.ID)
+	if _, err := stripe.VerifyWebhook(append(payload, ' '), signature); !errors.Is(err, payments.ErrInvalidSignature) {
+		t.Errorf("tampered webhook returned %v, want ErrInvalidSignature", err)
+	}
+
+	// Without a secret there is nothing to check events against
+	unsigned := payments.NewStripe(server.URL, server.SecretKey, "")
+	if _, err := unsigned.VerifyWebhook(payload, payments.SignWebhook("", payload, unsigned.Now())); !errors.Is(err, payments.ErrInvalidSignature) {
+		t.Errorf("webhook accepted without a secret: %v", err)
+	}
+}
+
+func TestFakeRefusesWebhooksWithoutSecret(t *testing.T) {
+	fake := payments.NewFake()
+	payload := []byte(`{"id":"evt_1","type":"payment.released","paymentId":"fake_pay_1"}`)
+	if _, err := fake.VerifyWebhook(payload, payments.SignWebhook("", payload, fake.Now())); !errors.Is(err, payments.ErrInvalidSignature) {
+		t.Errorf("fake accepted a webhook without a secret: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+// Package policy decides which users may perform which actions. Every role,
+// verification and ownership rule lives in the rules table below, so granting
+// a role a new permission means changing that table only.
+package policy
+
+import (
+	"time"
+
+	"nextchapter.com/m/models"
+)
+
+// Action is something a user can attempt
+type Action string
+
+const (
+	CreateBook       Action = "book:create"
+	UpdateBook       Action = "book:update"
+	DeleteBook       Action = "book:delete"
+	UpdateBookStatus Action = "book:update-status"
+	RequestBook      Action = "book:request"
+	ListOwnedBooks   Action = "book:list-owned"
+	ListRentedBooks  Action = "book:list-rented"
+	ListBookRequests Action = "book:list-requests"
+	JoinWaitlist     Action = "book:join-waitlist"
+	ManageWaitlist   Action = "book:manage-waitlist"
+	DecideRequest    Action = "request:decide"
+	CancelRequest    Action = "request:cancel"
+	ViewRental       Action = "rental:view"
+	ReturnRental     Action = "rental:return"
+	ConfirmReturn    Action = "rental:confirm-return"
+	ExtendRental     Action = "rental:extend"
+	DecideExtension  Action = "rental:decide-extension"
+	Administer       Action = "admin"
+
+	// ViewBookContact covers a listing's contact info, the resource is the book
+	ViewBookContact Action = "book:view-contact"
+	// ViewUserContact covers contact details set to renters only, the resource is the user
+	ViewUserContact Action = "user:view-contact"
+)
+
+// Denial explains why an action was refused. Its message is meant for the user.
+type Denial struct {
+	Message string
+}
+
+func (d *Denial) Error() string {
+	return d.Message
+}
+
+// ErrUnverified is returned for actions that need a confirmed email address
+var ErrUnverified = &Denial{Message: "Please verify your email address first"}
+
+// ErrSuspended is returned for every action while the user is suspended
+var ErrSuspended = &Denial{Message: "Your account is suspended"}
+
+// Rule describes who may perform an action
+type Rule struct {
+	// Roles lists the roles allowed to perform the action; holding any one of
+	// them is enough. Empty means any user.
+	Roles []string
+	// RequireVerified limits the action to users with a confirmed email
+	RequireVerified bool
+	// Denied is the message given to users without one of the Roles
+	Denied string
+	// Resource, if set, checks the user against the resource being acted on
+	Resource func(user models.User, resource any) bool
+	// ResourceDenied is the message given when the Resource check fails
+	ResourceDenied string
+}
+
+var rules = map[Action]Rule{
+	CreateBook: {
+		Roles:           []string{models.RoleOwner},
+		RequireVerified: true,
+		Denied:          "Only book owners can create listings",
+	},
+	UpdateBook: {
+		Resource:       ownsBook,
+		ResourceDenied: "You can only update your own books",
+	},
+	DeleteBook: {
+		Resource:       ownsBook,
+		ResourceDenied: "You can only delete your own books",
+	},
+	UpdateBookStatus: {
+		Resource:       ownsBook,
+		ResourceDenied: "You can only update your own books",
+	},
+	RequestBook: {
+		Roles:           []string{models.RoleSeeker},
+		RequireVerified: true,
+		Denied:          "Only seekers can request books",
+		Resource:        notOwnBook,
+		ResourceDenied:  "You cannot request your own book",
+	},
+	ListBookRequests: {
+		Resource:       ownsBook,
+		ResourceDenied: "You can only see requests for your own books",
+	},
+	JoinWaitlist: {
+		Roles:           []string{models.RoleSeeker},
+		RequireVerified: true,
+		Denied:          "Only seekers can join waitlists",
+		Resource:        notOwnBook,
+		ResourceDenied:  "You cannot join the waitlist for your own book",
+	},
+	ManageWaitlist: {
+		Resource:       ownsBook,
+		ResourceDenied: "You can only manage the waitlists of your own books",
+	},
+	DecideRequest: {
+		Resource:       receivedRequest,
+		ResourceDenied: "You can only approve or decline requests for your own books",
+	},
+	CancelRequest: {
+		Resource:       sentRequest,
+		ResourceDenied: "You can only cancel your own requests",
+	},
+	ViewRental: {
+		Resource:       rentalParty,
+		ResourceDenied: "You can only see your own rentals",
+	},
+	ReturnRental: {
+		Resource:       borrowedRental,
+		ResourceDenied: "Only the renter can mark a book as returned",
+	},
+	ConfirmReturn: {
+		Resource:       lentRental,
+		ResourceDenied: "Only the owner can confirm a book was returned",
+	},
+	ExtendRental: {
+		Resource:       borrowedRental,
+		ResourceDenied: "Only the renter can ask for more time",
+	},
+	DecideExtension: {
+		Resource:       lentRental,
+		ResourceDenied: "Only the owner can approve or decline an extension",
+	},
+	ListOwnedBooks: {
+		Roles:  []string{models.RoleOwner},
+		Denied: "Only owners can access this endpoint",
+	},
+	ListRentedBooks: {
+		Roles:  []string{models.RoleSeeker},
+		Denied: "Only seekers can access this endpoint",
+	},
+	Administer: {
+		Roles:  []string{models.RoleAdmin},
+		Denied: "This action requires admin privileges",
+	},
+	ViewBookContact: {
+		Resource:       ownsOrRentsBook,
+		ResourceDenied: "Contact details are shared once the owner accepts your rental",
+	},
+	ViewUserContact: {
+		Resource:       sharesRental,
+		ResourceDenied: "Contact details are shared once a rental is accepted",
+	},
+}
+
+// Check returns nil if the user may perform the action on the resource, or an
+// error explaining why not. With a nil resource only the role and verification
+// rules are checked; this is how route middleware gates a whole endpoint, and
+// handlers must check again once they have loaded the resource.
+func Check(user models.User, action Action, resource any) error {
+	rule, exists := rules[action]
+	if !exists {
+		return &Denial{Message: "This action is not allowed"}
+	}
+
+	if user.IsSuspended(time.Now()) {
+		return ErrSuspended
+	}
+	if len(rule.Roles) > 0 && !hasAnyRole(user, rule.Roles) {
+		return &Denial{Message: rule.Denied}
+	}
+	if rule.RequireVerified && !user.EmailVerified {
+		return ErrUnverified
+	}
+	if resource != nil && rule.Resource != nil && !rule.Resource(user, resource) {
+		return &Denial{Message: rule.ResourceDenied}
+	}
+	return nil
+}
+
+// Can reports whether the user may perform the action on the resource
+func Can(user models.User, action Action, resource any) bool {
+	return Check(user, action, resource) == nil
+}
+
+// hasAnyRole reports whether the user holds one of the roles
+func hasAnyRole(user models.User, roles []string) bool {
+	for _, role := range roles {
+		if user.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// ownsBook reports whether the resource is a book owned by the user
+func ownsBook(user models.User, resource any) bool {
+	book, ok := resource.(models.Book)
+	return ok && book.OwnerID == user.ID
+}
+
+// notOwnBook reports whether the resource is a book owned by someone else
+func notOwnBook(user models.User, resource any) bool {
+	book, ok := resource.(models.Book)
+	return ok && book.OwnerID != user.ID
+}
+
+// receivedRequest reports whether the resource is a rental request for one of the user's books
+func receivedRequest(user models.User, resource any) bool {
+	request, ok := resource.(models.RentalRequest)
+	return ok && request.OwnerID == user.ID
+}
+
+// sentRequest reports whether the resource is a rental request the user made
+func sentRequest(user models.User, resource any) bool {
+	request, ok := resource.(models.RentalRequest)
+	return ok && request.SeekerID == user.ID
+}
+
+// rentalParty reports whether the resource is a rental the user lent or borrowed
+func rentalParty(user models.User, resource any) bool {
+	rental, ok := resource.(models.Rental)
+	return ok && (rental.OwnerID == user.ID || rental.RenterID == user.ID)
+}
+
+// borrowedRental reports whether the resource is a rental the user is the renter on
+func borrowedRental(user models.User, resource any) bool {
+	rental, ok := resource.(models.Rental)
+	return ok && rental.RenterID == user.ID
+}
+
+// lentRental reports whether the resource is a rental of one of the user's books
+func lentRental(user models.User, resource any) bool {
+	rental, ok := resource.(models.Rental)
+	return ok && rental.OwnerID == user.ID
+}
+
+// ownsOrRentsBook reports whether the resource is a book the user owns or
+// currently has a copy of out on an accepted rental
+func ownsOrRentsBook(user models.User, resource any) bool {
+	book, ok := resource.(models.Book)
+	if !ok || user.ID == "" {
+		return false
+	}
+	if book.OwnerID == user.ID {
+		return true
+	}
+	for _, rental := range models.GetActiveRentalsForBook(book.ID) {
+		if rental.RenterID == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// sharesRental reports whether the resource is the user themselves or a user
+// who has accepted a rental with them, in either direction
+func sharesRental(user models.User, resource any) bool {
+	other, ok := resource.(models.User)
+	if !ok || user.ID == "" {
+		return false
+	}
+	return other.ID == user.ID || models.HasAcceptedRental(other.ID, user.ID) || models.HasAcceptedRental(user.ID, other.ID)
+}
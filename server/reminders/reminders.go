@@ -0,0 +1,166 @@
+// Package reminders watches rental due dates. It marks rentals overdue once
+// their due date passes and reminds renters shortly before the due date and
+// regularly after it.
+package reminders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"nextchapter.com/m/config"
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/notify"
+)
+
+// Scheduler checks active rentals against their due dates
+type Scheduler struct {
+	// Interval is how often Run checks the rentals
+	Interval time.Duration
+	// DueSoon is how long before the due date the renter is reminded
+	DueSoon time.Duration
+	// OverdueEvery is how often the renter of an overdue book is reminded
+	OverdueEvery time.Duration
+	// Tasks are other time-based jobs, such as expiring waitlist offers, run
+	// at the end of every check
+	Tasks []func(now time.Time)
+}
+
+// NewScheduler returns a scheduler using the configured intervals
+func NewScheduler() *Scheduler {
+	cfg := config.Get()
+	return &Scheduler{
+		Interval:     cfg.ReminderInterval,
+		DueSoon:      cfg.DueSoonReminder,
+		OverdueEvery: cfg.OverdueReminderInterval,
+	}
+}
+
+// Run checks the rentals straight away and then every Interval until the
+// context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.Check(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check marks overdue rentals, sends the reminders that are due at now and
+// runs the Tasks
+func (s *Scheduler) Check(now time.Time) {
+	for _, rental := range models.GetActiveRentals() {
+		if rental.DueAt == nil {
+			continue
+		}
+		// Once the renter has handed the book back it's up to the owner
+		book, exists := models.GetBookByID(rental.BookID)
+		if !exists {
+			continue
+		}
+		if bookCopy := book.Copy(rental.CopyID); bookCopy == nil || bookCopy.Status != models.BookCheckedOut {
+			continue
+		}
+		if err := s.checkRental(rental, now); err != nil {
+			log.Printf("Failed to check rental %s for reminders: %v", rental.ID, err)
+		}
+	}
+	for _, task := range s.Tasks {
+		task(now)
+	}
+}
+
+// Reminders checkRental can send
+const (
+	reminderOverdue      = "overdue"       // the due date has just passed
+	reminderOverdueAgain = "overdue-again" // still overdue since the last reminder
+	reminderDueSoon      = "due-soon"
+)
+
+// reminderDue returns the reminder the rental is due at now, or "" if none
+func (s *Scheduler) reminderDue(rental models.Rental, now time.Time) string {
+	if rental.DueAt == nil {
+		return ""
+	}
+	due := *rental.DueAt
+	switch {
+	case !now.Before(due) && !rental.Overdue:
+		return reminderOverdue
+	case rental.Overdue && rental.OverdueRemindedAt != nil && now.Sub(*rental.OverdueRemindedAt) >= s.OverdueEvery:
+		return reminderOverdueAgain
+	case now.Before(due) && due.Sub(now) <= s.DueSoon && rental.DueSoonRemindedAt == nil:
+		return reminderDueSoon
+	}
+	return ""
+}
+
+// checkRental handles one checked out rental. Check reads the rentals without
+// the handlers' lock, so an extension may have moved the due date since, or
+// the rental may have ended. The reminder is decided again on the saved
+// rental and only recorded and sent if it still applies.
+func (s *Scheduler) checkRental(rental models.Rental, now time.Time) error {
+	kind := s.reminderDue(rental, now)
+	if kind == "" {
+		return nil
+	}
+	applies := false
+	rental, err := models.UpdateRental(rental.ID, func(r *models.Rental) {
+		if s.reminderDue(*r, now) != kind {
+			return
+		}
+		applies = true
+		switch kind {
+		case reminderOverdue:
+			r.Overdue = true
+			r.OverdueRemindedAt = &now
+			r.History = append(r.History,
+				models.RentalEvent{Type: models.RentalEventOverdue, At: now},
+				models.RentalEvent{Type: models.RentalEventReminder, At: now, Note: "Overdue reminder"})
+		case reminderOverdueAgain:
+			r.OverdueRemindedAt = &now
+			r.History = append(r.History, models.RentalEvent{Type: models.RentalEventReminder, At: now, Note: "Overdue reminder"})
+		case reminderDueSoon:
+			r.DueSoonRemindedAt = &now
+			r.History = append(r.History, models.RentalEvent{Type: models.RentalEventReminder, At: now, Note: "Due soon reminder"})
+		}
+	})
+	if errors.Is(err, models.ErrRentalNotActive) || (err == nil && !applies) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	due := *rental.DueAt
+	dueDate := due.Format("Monday 2 January")
+	switch kind {
+	case reminderOverdue:
+		s.send(rental.RenterID, rental.BookTitle+" is overdue",
+			fmt.Sprintf("\"%s\" was due back on %s. Please return it to the owner as soon as you can.", rental.BookTitle, dueDate))
+		s.send(rental.OwnerID, rental.BookTitle+" is overdue",
+			fmt.Sprintf("\"%s\" was due back on %s and hasn't been returned yet. We've reminded the renter.", rental.BookTitle, dueDate))
+	case reminderOverdueAgain:
+		days := int(now.Sub(due).Hours() / 24)
+		s.send(rental.RenterID, "Reminder: "+rental.BookTitle+" is overdue",
+			fmt.Sprintf("\"%s\" was due back on %s, %d days ago. Please return it to the owner.", rental.BookTitle, dueDate, days))
+	case reminderDueSoon:
+		s.send(rental.RenterID, rental.BookTitle+" is due soon",
+			fmt.Sprintf("\"%s\" is due back on %s. If you need more time, ask the owner before then.", rental.BookTitle, dueDate))
+	}
+	return nil
+}
+
+// send delivers a reminder through the notifier, logging failures so one bad
+// address doesn't stop the rest
+func (s *Scheduler) send(userID, subject, body string) {
+	if err := notify.User(userID, subject, body); err != nil {
+		log.Printf("Failed to send reminder to user %s: %v", userID, err)
+	}
+}
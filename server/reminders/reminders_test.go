@@ -0,0 +1,99 @@
+package reminders
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"nextchapter.com/m/models"
+	"nextchapter.com/m/notify"
+)
+
+// recordingNotifier keeps the subjects of the messages sent during a test
+type recordingNotifier struct {
+	mu       sync.Mutex
+	subjects []string
+}
+
+func (n *recordingNotifier) Notify(user models.User, subject, body string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.subjects = append(n.subjects, subject)
+	return nil
+}
+
+// newReminderTest saves a renter and an owner and returns a scheduler whose
+// messages are recorded
+func newReminderTest(t *testing.T) (*Scheduler, *recordingNotifier) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"reminder-renter", "reminder-owner"} {
+		if err := models.SaveUser(models.User{ID: id, Name: id, Email: id + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := &recordingNotifier{}
+	notify.SetNotifier(sent)
+	t.Cleanup(func() { notify.SetNotifier(notify.EmailNotifier{}) })
+	return &Scheduler{DueSoon: 48 * time.Hour, OverdueEvery: 72 * time.Hour}, sent
+}
+
+func saveRental(t *testing.T, id string, dueAt time.Time) models.Rental {
+	t.Helper()
+	rental := models.Rental{
+		ID:        id,
+		BookID:    "book-" + id,
+		CopyID:    "1",
+		BookTitle: "Middlemarch",
+		OwnerID:   "reminder-owner",
+		RenterID:  "reminder-renter",
+		StartedAt: dueAt.AddDate(0, 0, -14),
+		DueAt:     &dueAt,
+	}
+	if err := models.SaveRental(rental); err != nil {
+		t.Fatal(err)
+	}
+	return rental
+}
+
+func TestCheckRentalMarksOverdue(t *testing.T) {
+	s, sent := newReminderTest(t)
+	now := time.Now()
+	rental := saveRental(t, "reminder-overdue", now.Add(-time.Hour))
+
+	if err := s.checkRental(rental, now); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := models.GetRentalByID(rental.ID); !saved.Overdue {
+		t.Error("rental past its due date was not marked overdue")
+	}
+	if len(sent.subjects) != 2 {
+		t.Errorf("sent %v, want the renter's and owner's overdue notices", sent.subjects)
+	}
+}
+
+func TestCheckRentalSkipsStaleSnapshot(t *testing.T) {
+	s, sent := newReminderTest(t)
+	now := time.Now()
+	stale := saveRental(t, "reminder-extended", now.Add(-time.Hour))
+
+	// An extension moves the due date after Check read the rental
+	extended := now.AddDate(0, 0, 7)
+	if _, err := models.UpdateRental(stale.ID, func(r *models.Rental) { r.DueAt = &extended }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.checkRental(stale, now); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := models.GetRentalByID(stale.ID); saved.Overdue || saved.OverdueRemindedAt != nil {
+		t.Errorf("extended rental was marked overdue: %+v", saved)
+	}
+	if len(sent.subjects) != 0 {
+		t.Errorf("sent %v for a rental that is no longer overdue", sent.subjects)
+	}
+}
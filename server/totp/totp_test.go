@@ -0,0 +1,84 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890"
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeAtMatchesRFC6238(t *testing.T) {
+	// The RFC's 8-digit codes, of which the last six are ours
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != v.code {
+			t.Errorf("code at %d is %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestCodeAtRejectsInvalidSecret(t *testing.T) {
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Error("invalid secret was accepted")
+	}
+}
+
+func TestValidateAllowsSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	for offset := int64(-Skew); offset <= Skew; offset++ {
+		code, _ := CodeAt(rfcSecret, step+offset)
+		if matched, ok := Validate(rfcSecret, code, now, 0); !ok || matched != step+offset {
+			t.Errorf("code %d steps away was not accepted as step %d", offset, step+offset)
+		}
+	}
+	for _, offset := range []int64{-Skew - 1, Skew + 1} {
+		code, _ := CodeAt(rfcSecret, step+offset)
+		if _, ok := Validate(rfcSecret, code, now, 0); ok {
+			t.Errorf("code %d steps away was accepted", offset)
+		}
+	}
+}
+
+func TestValidateRejectsReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := CodeAt(rfcSecret, Step(now))
+	matched, ok := Validate(rfcSecret, code, now, 0)
+	if !ok {
+		t.Fatal("current code was not accepted")
+	}
+	if _, ok := Validate(rfcSecret, code, now, matched); ok {
+		t.Error("code was accepted twice")
+	}
+	// An earlier code is refused once a later one has been used
+	earlier, _ := CodeAt(rfcSecret, Step(now)-1)
+	if _, ok := Validate(rfcSecret, earlier, now, matched); ok {
+		t.Error("code from before the last accepted step was accepted")
+	}
+}
+
+func TestValidateNormalizesInput(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	if _, ok := Validate(rfcSecret, " 005 924 ", now, 0); !ok {
+		t.Error("code with spaces was not accepted")
+	}
+	if _, ok := Validate(rfcSecret, "5924", now, 0); ok {
+		t.Error("short code was accepted")
+	}
+}
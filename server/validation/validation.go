@@ -0,0 +1,178 @@
+// Package validation describes problems with individual fields of a request
+// in a form clients can show next to the matching input.
+//
+// Models declare their rules with `validate` struct tags, using the rules of
+// github.com/go-playground/validator, and handlers check them with Struct.
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError is a problem with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`   // JSON name of the field
+	Code    string `json:"code"`    // machine readable reason, such as "required"
+	Message string `json:"message"` // human readable explanation
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	// Report fields by their JSON names, which is what clients send
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	return v
+}
+
+// Struct checks a struct against its validate tags and returns one error per
+// failing field. Nested fields are named with dots, such as "privacy.email".
+func Struct(s any) []FieldError {
+	err := validate.Struct(s)
+	if err == nil {
+		return nil
+	}
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return []FieldError{{Field: "", Code: "invalid", Message: err.Error()}}
+	}
+
+	errs := make([]FieldError, 0, len(invalid))
+	for _, fe := range invalid {
+		field := fe.Namespace()
+		// Drop the struct's own name from the front of the path
+		if _, rest, found := strings.Cut(field, "."); found {
+			field = rest
+		}
+		errs = append(errs, FieldError{Field: field, Code: code(fe), Message: message(fe)})
+	}
+	return errs
+}
+
+// FromBindError describes a request body that could not be decoded
+func FromBindError(err error) []FieldError {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		return []FieldError{{Field: typeErr.Field, Code: "type", Message: fmt.Sprintf("Must be a %s", jsonType(typeErr.Type))}}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return []FieldError{{Field: "", Code: "malformed", Message: "Request body is not valid JSON"}}
+	case errors.Is(err, io.EOF):
+		return []FieldError{{Field: "", Code: "required", Message: "Request body is required"}}
+	}
+	var invalid validator.ValidationErrors
+	if errors.As(err, &invalid) {
+		// Binding tags on handler-local request structs. Gin reports Go field
+		// names, which match the JSON names apart from the first letter.
+		errs := make([]FieldError, 0, len(invalid))
+		for _, fe := range invalid {
+			field := fe.Field()
+			if field != "" {
+				field = strings.ToLower(field[:1]) + field[1:]
+			}
+			errs = append(errs, FieldError{Field: field, Code: code(fe), Message: message(fe)})
+		}
+		return errs
+	}
+	return []FieldError{{Field: "", Code: "invalid", Message: err.Error()}}
+}
+
+// NormalizePhone removes the spaces, dashes, dots and brackets people type in
+// phone numbers, leaving the digits and any leading plus sign
+func NormalizePhone(phone string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(phone))
+}
+
+// code maps a validator tag to the code reported to clients
+func code(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "required"
+	case "max":
+		return "too_long"
+	case "min":
+		return "too_short"
+	case "lte":
+		return "too_large"
+	case "gte":
+		return "too_small"
+	case "email":
+		return "invalid_email"
+	case "e164":
+		return "invalid_phone"
+	case "oneof":
+		return "invalid_choice"
+	case "url", "http_url":
+		return "invalid_url"
+	case "iso4217":
+		return "invalid_currency"
+	}
+	return fe.Tag()
+}
+
+// message explains a failed rule in words
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "This field is required"
+	case "max":
+		return fmt.Sprintf("Must be at most %s characters", fe.Param())
+	case "min":
+		return fmt.Sprintf("Must be at least %s characters", fe.Param())
+	case "lte":
+		return fmt.Sprintf("Must be at most %s", fe.Param())
+	case "gte":
+		return fmt.Sprintf("Must be at least %s", fe.Param())
+	case "email":
+		return "Must be a valid email address"
+	case "e164":
+		return "Must be a phone number in international format, such as +14155552671"
+	case "oneof":
+		return fmt.Sprintf("Must be one of: %s", strings.Join(strings.Fields(fe.Param()), ", "))
+	case "url", "http_url":
+		return "Must be a valid http or https URL"
+	case "iso4217":
+		return "Must be a three-letter ISO 4217 currency code, such as USD"
+	}
+	return fmt.Sprintf("Failed the %s check", fe.Tag())
+}
+
+// jsonType names a Go type the way a client would think of it
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	}
+	return "object"
+}
@@ -0,0 +1,364 @@
+// Package webauthn implements the relying party side of the WebAuthn
+// registration and authentication ceremonies used for passkeys.
+//
+// Attestation statements are not verified: registration asks authenticators
+// for "none" attestation, which is what passkey providers send, and the
+// credential is trusted because the signed-in user registered it.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// ceremonyTimeout is the time in milliseconds the browser gives the user to respond
+const ceremonyTimeout = 120000
+
+// RelyingParty identifies this site to authenticators
+type RelyingParty struct {
+	ID      string   // effective domain, e.g. "nextchapter.example"
+	Name    string   // shown by the authenticator
+	Origins []string // web origins allowed to run the ceremonies
+}
+
+// Credential is a registered public key credential
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key encoded
+	Algorithm int
+	SignCount uint32
+	AAGUID    []byte
+}
+
+// UserEntity describes the account a credential is created for
+type UserEntity struct {
+	ID          string `json:"id"` // base64url encoded user handle
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialDescriptor refers to an existing credential
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"` // base64url encoded credential ID
+}
+
+// CreationOptions are passed to navigator.credentials.create(), in the JSON
+// form read by PublicKeyCredential.parseCreationOptionsFromJSON()
+type CreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User             UserEntity `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions are passed to navigator.credentials.get(), in the JSON form
+// read by PublicKeyCredential.parseRequestOptionsFromJSON()
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the JSON form of the credential returned by navigator.credentials.create()
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the credential returned by navigator.credentials.get()
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// BeginRegistration creates the options for registering a new credential for
+// the user. The returned challenge must be kept for FinishRegistration.
+func (rp RelyingParty) BeginRegistration(user UserEntity, existing [][]byte) (CreationOptions, []byte, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return CreationOptions{}, nil, err
+	}
+
+	var opts CreationOptions
+	opts.Challenge = Encode(challenge)
+	opts.RP.ID = rp.ID
+	opts.RP.Name = rp.Name
+	opts.User = user
+	for _, alg := range []int{AlgES256, AlgEdDSA, AlgRS256} {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{Type: "public-key", Alg: alg})
+	}
+	opts.Timeout = ceremonyTimeout
+	opts.ExcludeCredentials = descriptors(existing)
+	opts.AuthenticatorSelection.ResidentKey = "preferred"
+	opts.AuthenticatorSelection.UserVerification = "preferred"
+	opts.Attestation = "none"
+	return opts, challenge, nil
+}
+
+// FinishRegistration verifies the browser's response to BeginRegistration and
+// returns the new credential
+func (rp RelyingParty) FinishRegistration(challenge []byte, resp RegistrationResponse) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, errors.New("unexpected credential type")
+	}
+	if err := rp.checkClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := Decode(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, errors.New("malformed attestation object")
+	}
+	v, _, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return nil, errors.New("malformed attestation object")
+	}
+	attestation, ok := v.(map[any]any)
+	if !ok {
+		return nil, errors.New("malformed attestation object")
+	}
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authenticator data")
+	}
+
+	flags, signCount, rest, err := rp.parseAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagAttested == 0 {
+		return nil, errors.New("authenticator data has no credential")
+	}
+
+	// Attested credential data: AAGUID, credential ID length and ID, then the COSE key
+	if len(rest) < 18 {
+		return nil, errors.New("malformed attested credential data")
+	}
+	aaguid := rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, errors.New("malformed credential ID")
+	}
+	credentialID := rest[:idLength]
+	_, keyLength, err := decodeCBOR(rest[idLength:])
+	if err != nil {
+		return nil, errors.New("malformed credential public key")
+	}
+	publicKey := rest[idLength : idLength+keyLength]
+
+	rawID, err := Decode(resp.RawID)
+	if err != nil || !bytes.Equal(rawID, credentialID) {
+		return nil, errors.New("credential ID does not match the authenticator data")
+	}
+
+	_, alg, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        append([]byte(nil), credentialID...),
+		PublicKey: append([]byte(nil), publicKey...),
+		Algorithm: alg,
+		SignCount: signCount,
+		AAGUID:    append([]byte(nil), aaguid...),
+	}, nil
+}
+
+// BeginLogin creates the options for signing in. With no allowed credentials
+// the browser offers any passkey it holds for this site.
+func (rp RelyingParty) BeginLogin(allowed [][]byte) (RequestOptions, []byte, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return RequestOptions{}, nil, err
+	}
+	return RequestOptions{
+		Challenge:        Encode(challenge),
+		Timeout:          ceremonyTimeout,
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allowed),
+		UserVerification: "preferred",
+	}, challenge, nil
+}
+
+// Assertion is the result of a verified login
+type Assertion struct {
+	SignCount    uint32 // new signature counter, which the caller must store
+	UserVerified bool   // the authenticator checked a PIN or biometric
+}
+
+// FinishLogin verifies an assertion made with the stored credential. A
+// signature counter that does not increase suggests a cloned authenticator and
+// the assertion is rejected.
+func (rp RelyingParty) FinishLogin(challenge []byte, resp AssertionResponse, cred Credential) (Assertion, error) {
+	if resp.Type != "public-key" {
+		return Assertion{}, errors.New("unexpected credential type")
+	}
+	rawID, err := Decode(resp.RawID)
+	if err != nil || !bytes.Equal(rawID, cred.ID) {
+		return Assertion{}, errors.New("assertion is for a different credential")
+	}
+	if err := rp.checkClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return Assertion{}, err
+	}
+
+	authData, err := Decode(resp.Response.AuthenticatorData)
+	if err != nil {
+		return Assertion{}, errors.New("malformed authenticator data")
+	}
+	flags, signCount, _, err := rp.parseAuthData(authData)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	clientData, _ := Decode(resp.Response.ClientDataJSON)
+	clientDataHash := sha256.Sum256(clientData)
+	signature, err := Decode(resp.Response.Signature)
+	if err != nil {
+		return Assertion{}, errors.New("malformed signature")
+	}
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	if err := verifySignature(cred.PublicKey, signed, signature); err != nil {
+		return Assertion{}, err
+	}
+
+	// Authenticators that don't count always report zero
+	if (signCount != 0 || cred.SignCount != 0) && signCount <= cred.SignCount {
+		return Assertion{}, errors.New("signature counter did not increase, the authenticator may have been cloned")
+	}
+	return Assertion{SignCount: signCount, UserVerified: flags&flagUserVerified != 0}, nil
+}
+
+// checkClientData verifies the ceremony type, challenge and origin in clientDataJSON
+func (rp RelyingParty) checkClientData(encoded, ceremony string, challenge []byte) error {
+	raw, err := Decode(encoded)
+	if err != nil {
+		return errors.New("malformed client data")
+	}
+	var clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return errors.New("malformed client data")
+	}
+
+	if clientData.Type != ceremony {
+		return errors.New("client data is for the wrong ceremony")
+	}
+	got, err := Decode(clientData.Challenge)
+	if err != nil || !bytes.Equal(got, challenge) {
+		return errors.New("challenge does not match")
+	}
+	for _, origin := range rp.Origins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+	return errors.New("origin is not allowed")
+}
+
+// parseAuthData checks the RP ID hash and user presence flag and returns the
+// flags, signature counter and any data that follows
+func (rp RelyingParty) parseAuthData(authData []byte) (byte, uint32, []byte, error) {
+	if len(authData) < 37 {
+		return 0, 0, nil, errors.New("authenticator data is too short")
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return 0, 0, nil, errors.New("authenticator data is for a different site")
+	}
+	flags := authData[32]
+	if flags&flagUserPresent == 0 {
+		return 0, 0, nil, errors.New("user was not present")
+	}
+	return flags, binary.BigEndian.Uint32(authData[33:37]), authData[37:], nil
+}
+
+// ChallengeFromClientData extracts the challenge from a base64url encoded
+// clientDataJSON, so the server can find the ceremony it belongs to
+func ChallengeFromClientData(encoded string) ([]byte, error) {
+	raw, err := Decode(encoded)
+	if err != nil {
+		return nil, errors.New("malformed client data")
+	}
+	var clientData struct {
+		Challenge string `json:"challenge"`
+	}
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return nil, errors.New("malformed client data")
+	}
+	return Decode(clientData.Challenge)
+}
+
+// Encode returns the unpadded base64url encoding used throughout WebAuthn
+func Encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Decode accepts base64url with or without padding
+func Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: Encode(id)})
+	}
+	return list
+}
+
+func newChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
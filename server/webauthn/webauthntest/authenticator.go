@@ -0,0 +1,204 @@
+// Package webauthntest provides a software authenticator for exercising the
+// passkey ceremonies without a browser or security key.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"nextchapter.com/m/webauthn"
+)
+
+// Authenticator holds ES256 passkeys in memory and answers ceremonies for a
+// single origin. Every operation reports user presence and verification.
+type Authenticator struct {
+	Origin string
+
+	credentials map[string]*credential // maps base64url credential ID to credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle string
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// New returns an authenticator that claims to run in the given origin
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, credentials: make(map[string]*credential)}
+}
+
+// Create answers navigator.credentials.create() with a new passkey
+func (a *Authenticator) Create(opts webauthn.CreationOptions) (webauthn.RegistrationResponse, error) {
+	var resp webauthn.RegistrationResponse
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return resp, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return resp, err
+	}
+	cred := &credential{id: id, rpID: opts.RP.ID, userHandle: opts.User.ID, key: key}
+
+	attested := make([]byte, 16, 16+2+len(id)) // all-zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, coseKey(&key.PublicKey)...)
+	authData := authenticatorData(cred, 0x01|0x04|0x40, attested)
+
+	clientData, err := a.clientData("webauthn.create", opts.Challenge)
+	if err != nil {
+		return resp, err
+	}
+
+	attestation := cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(authData),
+	)
+
+	a.credentials[webauthn.Encode(id)] = cred
+	resp.ID = webauthn.Encode(id)
+	resp.RawID = resp.ID
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = webauthn.Encode(clientData)
+	resp.Response.AttestationObject = webauthn.Encode(attestation)
+	return resp, nil
+}
+
+// Get answers navigator.credentials.get() with the first passkey allowed by
+// the options, or any passkey for the site when none are listed
+func (a *Authenticator) Get(opts webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	var resp webauthn.AssertionResponse
+
+	cred := a.find(opts)
+	if cred == nil {
+		return resp, errors.New("no matching passkey")
+	}
+	cred.signCount++
+
+	authData := authenticatorData(cred, 0x01|0x04, nil)
+	clientData, err := a.clientData("webauthn.get", opts.Challenge)
+	if err != nil {
+		return resp, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return resp, err
+	}
+
+	resp.ID = webauthn.Encode(cred.id)
+	resp.RawID = resp.ID
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = webauthn.Encode(clientData)
+	resp.Response.AuthenticatorData = webauthn.Encode(authData)
+	resp.Response.Signature = webauthn.Encode(signature)
+	resp.Response.UserHandle = cred.userHandle
+	return resp, nil
+}
+
+// SetSignCount overwrites a passkey's counter, for simulating a cloned authenticator
+func (a *Authenticator) SetSignCount(credentialID string, count uint32) {
+	if cred, exists := a.credentials[credentialID]; exists {
+		cred.signCount = count
+	}
+}
+
+func (a *Authenticator) find(opts webauthn.RequestOptions) *credential {
+	if len(opts.AllowCredentials) == 0 {
+		for _, cred := range a.credentials {
+			if cred.rpID == opts.RPID {
+				return cred
+			}
+		}
+		return nil
+	}
+	for _, allowed := range opts.AllowCredentials {
+		if cred, exists := a.credentials[allowed.ID]; exists && cred.rpID == opts.RPID {
+			return cred
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+func authenticatorData(cred *credential, flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, cred.signCount)
+	return append(data, attested...)
+}
+
+// coseKey encodes a P-256 public key as an ES256 COSE_Key
+func coseKey(pub *ecdsa.PublicKey) []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	return cborMap(
+		cborInt(1), cborInt(2), // kty: EC2
+		cborInt(3), cborInt(-7), // alg: ES256
+		cborInt(-1), cborInt(1), // crv: P-256
+		cborInt(-2), cborBytes(x),
+		cborInt(-3), cborBytes(y),
+	)
+}
+
+// Minimal CBOR encoding, enough for attestation objects and COSE keys
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
+
+func cborInt(n int64) []byte {
+	if n < 0 {
+		return cborHead(1, uint64(-1-n))
+	}
+	return cborHead(0, uint64(n))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+// cborMap encodes alternating keys and values
+func cborMap(items ...[]byte) []byte {
+	out := cborHead(5, uint64(len(items)/2))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}